@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile applies the settings of the YAML config file to the flags.
+// Keys are flag names ('_' is accepted for '-'), and a flag given on the
+// command line is not overridden, and a flag set by the file takes precedence
+// over its environment variable. A sequence value sets a repeatable flag
+// once per item.
+//
+//	port: 8899
+//	notebook_dir: ~/notebooks
+func loadConfigFile(path string) error {
+	entries, err := parseConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if isFlagSet(e.name) {
+			continue
+		}
+		for _, v := range e.values {
+			if err := flag.Set(e.name, v.Value); err != nil {
+				return fmt.Errorf("%s: line %d: key %q: invalid value %q: %v", path, v.Line, e.key, v.Value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configEntry is a key of the config file with its scalar values
+type configEntry struct {
+	name   string // flag name
+	key    string
+	values []*yaml.Node
+}
+
+func parseConfigFile(path string) ([]configEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: line %d: expected a mapping of options", path, root.Line)
+	}
+	var entries []configEntry
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		name := strings.ReplaceAll(key.Value, "_", "-")
+		if flag.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s: line %d: unknown key %q", path, key.Line, key.Value)
+		}
+		var values []*yaml.Node
+		switch val.Kind {
+		case yaml.ScalarNode:
+			values = []*yaml.Node{val}
+		case yaml.SequenceNode:
+			values = val.Content
+		default:
+			return nil, fmt.Errorf("%s: line %d: key %q: expected a value or a list", path, val.Line, key.Value)
+		}
+		for _, v := range values {
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s: line %d: key %q: expected a value", path, v.Line, key.Value)
+			}
+		}
+		entries = append(entries, configEntry{name: name, key: key.Value, values: values})
+	}
+	return entries, nil
+}
+
+// reloadConfigFile re-reads notebook-dir and set of the config file,
+// the ones given on the command line are kept.
+func reloadConfigFile(path string, cmdline map[string]bool, notebookDir string, settings map[string]string, presets map[string]string) (string, map[string]string, error) {
+	entries, err := parseConfigFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var dir string
+	var sets []string
+	for _, e := range entries {
+		switch e.name {
+		case "notebook-dir":
+			dir = e.values[len(e.values)-1].Value
+		case "set":
+			for _, v := range e.values {
+				sets = append(sets, v.Value)
+			}
+		}
+	}
+	if !cmdline["notebook-dir"] {
+		if dir != "" {
+			notebookDir = expandPath(dir)
+			if err := prepareNotebookDir(notebookDir); err != nil {
+				return "", nil, fmt.Errorf("invalid notebook dir: %v", err)
+			}
+		} else {
+			notebookDir = envNotebookDir(os.Getenv("MACHBASE_NEO_FILE"))
+		}
+		notebookDir = absNotebookDir(notebookDir)
+	}
+	if !cmdline["set"] {
+		settings, err = applySets(presets, sets)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid set: %v", err)
+		}
+	}
+	return notebookDir, settings, nil
+}
+
+// logReload logs the changes of the notebook dir and the settings
+func logReload(oldDir, newDir string, oldSettings, newSettings map[string]string) {
+	changed := false
+	if oldDir != newDir {
+		lg.Infof("reload: notebook-dir %s -> %s", oldDir, newDir)
+		changed = true
+	}
+	keys := make([]string, 0, len(oldSettings)+len(newSettings))
+	for k := range oldSettings {
+		keys = append(keys, k)
+	}
+	for k := range newSettings {
+		if _, ok := oldSettings[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o, oldOk := oldSettings[k]
+		n, newOk := newSettings[k]
+		switch {
+		case !oldOk:
+			lg.Infof("reload: set %s=%s", k, n)
+		case !newOk:
+			lg.Infof("reload: unset %s (was %s)", k, o)
+		case o != n:
+			lg.Infof("reload: set %s=%s (was %s)", k, n, o)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		lg.Infof("reload: no changes")
+	}
+}
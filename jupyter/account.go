@@ -0,0 +1,161 @@
+package jupyter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// Account is the user that jupyter runs as instead of the current one
+type Account struct {
+	Name   string
+	Home   string
+	Uid    int
+	Gid    int
+	Groups []int // supplementary groups
+}
+
+// LookupAccount returns the account of the user name or uid. The group name or gid
+// overrides the primary group of the user if not empty.
+func LookupAccount(name, group string) (*Account, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("running jupyter as another user is not supported on windows")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		var uerr error
+		if u, uerr = user.LookupId(name); uerr != nil {
+			return nil, err
+		}
+	}
+	a := &Account{Name: u.Username, Home: u.HomeDir}
+	if a.Uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("invalid uid %q of user %s", u.Uid, u.Username)
+	}
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			var gerr error
+			if g, gerr = user.LookupGroupId(group); gerr != nil {
+				return nil, err
+			}
+		}
+		gid = g.Gid
+	}
+	if a.Gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("invalid gid %q", gid)
+	}
+	ids, _ := u.GroupIds()
+	for _, id := range ids {
+		if n, err := strconv.Atoi(id); err == nil && n != a.Gid {
+			a.Groups = append(a.Groups, n)
+		}
+	}
+	return a, nil
+}
+
+func (a *Account) String() string {
+	return fmt.Sprintf("%s (uid %d, gid %d)", a.Name, a.Uid, a.Gid)
+}
+
+// Chown changes the owner of the paths and the files under them to the account
+func (a *Account) Chown(paths ...string) error {
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, a.Uid, a.Gid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Accessible returns an error if the account can not read, write and enter dir
+// by the permission bits of dir and its parents.
+func (a *Account) Accessible(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	want := fs.FileMode(07)
+	for p := dir; ; p = filepath.Dir(p) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !a.permitted(fi, want) {
+			if p == dir {
+				return fmt.Errorf("%s is not readable and writable by user %s", dir, a.Name)
+			}
+			return fmt.Errorf("%s is not accessible by user %s, %s denies it", dir, a.Name, p)
+		}
+		if parent := filepath.Dir(p); parent == p {
+			return nil
+		}
+		want = 01 // search permission of the parents
+	}
+}
+
+// permitted reports whether the permission bits of fi grant want (rwx as 07) to the account
+func (a *Account) permitted(fi fs.FileInfo, want fs.FileMode) bool {
+	uid, gid, ok := fileOwner(fi)
+	if !ok || a.Uid == 0 {
+		return true
+	}
+	perm := fi.Mode().Perm()
+	switch {
+	case uid == a.Uid:
+		perm >>= 6
+	case gid == a.Gid || containsInt(a.Groups, gid):
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAccount makes cmd run as the account with its HOME,
+// it is called after setProcAttr that replaces cmd.SysProcAttr.
+func (jl *JupyterLash) applyAccount(cmd *exec.Cmd) {
+	if jl.account == nil {
+		return
+	}
+	setCredential(cmd, jl.account)
+}
+
+// runsAsRoot reports whether jupyter is launched locally as root,
+// that is neither in docker nor as the account.
+func (jl *JupyterLash) runsAsRoot() bool {
+	return jl.dockerImage == "" && jl.account == nil && os.Geteuid() == 0
+}
+
+// checkAccount warns if the account can not use its home or the notebook dir
+func (jl *JupyterLash) checkAccount() {
+	if jl.account == nil {
+		return
+	}
+	jl.log("running jupyter as user %s", jl.account)
+	for _, dir := range []string{jl.account.Home, jl.notebookDir} {
+		if err := jl.account.Accessible(dir); err != nil {
+			jl.logWarn("%v", err)
+		}
+	}
+}
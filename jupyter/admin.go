@@ -0,0 +1,94 @@
+package jupyter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// startAdmin serves the admin api on adminAddr, the caller must hold the lock.
+//
+//	GET  /status   status of jupyter as JSON
+//	GET  /logs     last log lines as JSON, ?n= limits the number
+//	POST /restart  restart jupyter
+//	POST /stop     stop jupyter and neo-jupyter
+func (jl *JupyterLash) startAdmin() error {
+	if jl.adminAddr == "" || jl.adminSrv != nil {
+		return nil
+	}
+	if jl.adminToken == "" {
+		return fmt.Errorf("admin token is required")
+	}
+	ln, err := net.Listen("tcp", jl.adminAddr)
+	if err != nil {
+		return fmt.Errorf("fail to listen admin: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", jl.adminHandler(http.MethodGet, jl.adminStatus))
+	mux.HandleFunc("/logs", jl.adminHandler(http.MethodGet, jl.adminLogs))
+	mux.HandleFunc("/restart", jl.adminHandler(http.MethodPost, jl.adminRestart))
+	mux.HandleFunc("/stop", jl.adminHandler(http.MethodPost, jl.adminStop))
+	jl.adminSrv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go jl.adminSrv.Serve(ln)
+	jl.log("admin: http://%s", ln.Addr())
+	return nil
+}
+
+// adminHandler checks the method and the bearer token before calling h
+func (jl *JupyterLash) adminHandler(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		want := "Bearer " + jl.adminToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (jl *JupyterLash) adminStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jl.status.get())
+}
+
+func (jl *JupyterLash) adminLogs(w http.ResponseWriter, r *http.Request) {
+	if jl.status.logs == nil {
+		http.Error(w, "log buffer is disabled", http.StatusNotFound)
+		return
+	}
+	n := 0
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jl.status.logs.Tail(n))
+}
+
+func (jl *JupyterLash) adminRestart(w http.ResponseWriter, r *http.Request) {
+	jl.log("restarting by admin request from %s", r.RemoteAddr)
+	if err := jl.Restart(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (jl *JupyterLash) adminStop(w http.ResponseWriter, r *http.Request) {
+	jl.log("stopping by admin request from %s", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+	// Stop() shuts down this server, it can not wait for this handler
+	go jl.Stop()
+}
@@ -0,0 +1,30 @@
+package jupyter
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// openBrowser opens u in the default browser,
+// it does nothing if there is no display to open it.
+func (jl *JupyterLash) openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	case "darwin":
+		cmd = exec.Command("open", u)
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			jl.log("no display to open the browser")
+			return
+		}
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		jl.logError("fail to open the browser: %v", err)
+		return
+	}
+	go cmd.Wait()
+}
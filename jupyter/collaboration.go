@@ -0,0 +1,54 @@
+package jupyter
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	collaborationModule = "jupyter_collaboration"
+	collaborationPkg    = "jupyter-collaboration"
+)
+
+// prepareCollaboration enables real-time collaboration for the ui of version ver.
+// jupyterlab 3 has LabApp.collaborative, jupyterlab 4 and notebook 7 need
+// the jupyter-collaboration extension, that is installed if install is enabled.
+// It returns the package to install if offline, and warns if ver has no support.
+func (jl *JupyterLash) prepareCollaboration(ver string) (missing string, err error) {
+	major := majorVersion(ver)
+	switch {
+	case jl.ui == "lab" && major == 3:
+		jl.Lock()
+		if _, ok := jl.settings["LabApp.collaborative"]; !ok {
+			jl.settings["LabApp.collaborative"] = "True"
+		}
+		jl.Unlock()
+		return "", nil
+	case jl.ui == "lab" && major >= 4, jl.ui == "notebook" && major >= 7:
+	default:
+		jl.logWarn("%s %s does not support real-time collaboration, jupyterlab 3 or later is required", uis[jl.ui].module, ver)
+		return "", nil
+	}
+	if _, err := jl.detectModuleVersion(collaborationModule); err == nil {
+		return "", nil
+	}
+	switch {
+	case jl.offline:
+		return collaborationPkg, nil
+	case jl.install:
+		jl.log("%s is not available, installing...", collaborationModule)
+		if err := jl.pipInstall(collaborationPkg); err != nil {
+			return "", err
+		}
+	default:
+		jl.logWarn("%s is not available, real-time collaboration is disabled\nplease install it with: pip install %s", collaborationModule, collaborationPkg)
+	}
+	return "", nil
+}
+
+// majorVersion returns the major of ver, e.g. 4 of "4.1.2", 0 if unknown
+func majorVersion(ver string) int {
+	major, _, _ := strings.Cut(ver, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
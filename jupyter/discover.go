@@ -0,0 +1,184 @@
+package jupyter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func findPython() string {
+	// python3 only, a bare python may be python 2 on older systems
+	list := []string{
+		"/usr/bin/python3",
+		"/usr/local/bin/python3",
+		"/opt/python*/bin/python3",
+		"${HOME}/.pyenv/shims/python3",
+	}
+	switch runtime.GOOS {
+	case "windows":
+		list = []string{
+			`${LOCALAPPDATA}\Programs\Python\Python3*\python.exe`,
+			`${ProgramFiles}\Python3*\python.exe`,
+			`${LOCALAPPDATA}\Programs\Python\Launcher\py.exe`,
+			`${SystemRoot}\py.exe`,
+		}
+	case "darwin":
+		// homebrew of apple silicon and intel, python.org installer,
+		// and /usr/bin/python3 of xcode command line tools at last
+		list = []string{
+			"/opt/homebrew/bin/python3",
+			"/usr/local/bin/python3",
+			"/Library/Frameworks/Python.framework/Versions/3*/bin/python3",
+			"${HOME}/.pyenv/shims/python3",
+			"/usr/bin/python3",
+		}
+	}
+	if path := findPath(list); path != "" && !isXcodeStub(path) {
+		return path
+	}
+	if path := lookPath("python3", "python"); !isXcodeStub(path) {
+		return path
+	}
+	return ""
+}
+
+// isXcodeStub reports whether path is the python3 stub of macOS that
+// prompts to install the xcode command line tools instead of running python.
+func isXcodeStub(path string) bool {
+	if runtime.GOOS != "darwin" || path != "/usr/bin/python3" {
+		return false
+	}
+	return exec.Command("xcode-select", "-p").Run() != nil
+}
+
+func findJupyterExecutable() string {
+	list := []string{
+		"${HOME}/.local/bin/jupyter",
+		"/home/${USER}/.local/bin/jupyter",
+		"/usr/local/bin/jupyter",
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		list = []string{
+			"/opt/homebrew/bin/jupyter",
+			"/usr/local/bin/jupyter",
+			"${HOME}/Library/Python/3*/bin/jupyter",
+			"/Library/Frameworks/Python.framework/Versions/3*/bin/jupyter",
+			"${HOME}/.local/bin/jupyter",
+		}
+	case "windows":
+		list = []string{
+			`${APPDATA}\Python\Python3*\Scripts\jupyter.exe`,
+			`${LOCALAPPDATA}\Programs\Python\Python3*\Scripts\jupyter.exe`,
+			`${ProgramFiles}\Python3*\Scripts\jupyter.exe`,
+		}
+	}
+	if path := findPath(list); path != "" {
+		return path
+	}
+	return lookPath("jupyter")
+}
+
+// discoverExecutables finds python and jupyter concurrently, each of them only if
+// requested, and returns an error if the discovery does not finish in timeout,
+// e.g. blocked by a stale network filesystem.
+func discoverExecutables(python bool, jupyter bool, timeout time.Duration) (string, string, error) {
+	type result struct {
+		python bool
+		path   string
+	}
+	// buffered, so that a blocked finder does not leak after the timeout
+	resC := make(chan result, 2)
+	n := 0
+	if python {
+		n++
+		go func() { resC <- result{true, findPython()} }()
+	}
+	if jupyter {
+		n++
+		go func() { resC <- result{false, findJupyterExecutable()} }()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var pythonPath, jupyterPath string
+	for ; n > 0; n-- {
+		select {
+		case r := <-resC:
+			if r.python {
+				pythonPath = r.path
+			} else {
+				jupyterPath = r.path
+			}
+		case <-timer.C:
+			return "", "", fmt.Errorf("discovery of python and jupyter timed out after %s, please specify them with -python and -jupyter", timeout)
+		}
+	}
+	return pythonPath, jupyterPath, nil
+}
+
+// lookPath returns the first of names found in PATH
+func lookPath(names ...string) string {
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// findPath returns the first executable path in the list, the ones that
+// exist but are directories or not executable are skipped.
+// Environment variables are expanded, and patterns containing '*'
+// are globbed with the lexically greatest match preferred.
+func findPath(list []string) string {
+	for _, path := range list {
+		path = os.ExpandEnv(path)
+		if strings.Contains(path, "*") {
+			matches, _ := filepath.Glob(path)
+			for i := len(matches) - 1; i >= 0; i-- {
+				if checkExecutable(matches[i]) == nil {
+					return matches[i]
+				}
+			}
+			continue
+		}
+		if checkExecutable(path) == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// checkExecutable returns an error naming the path if it is missing or not executable
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if !isExecutable(path, fi) {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// findFreePort returns the first port in [port, port+count) that can be listened on
+func findFreePort(ip string, port int, count int) (int, error) {
+	for p := port; p < port+count && p <= 65535; p++ {
+		lsnr, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(p)))
+		if err != nil {
+			continue
+		}
+		lsnr.Close()
+		return p, nil
+	}
+	return 0, fmt.Errorf("no free port in %d-%d", port, port+count-1)
+}
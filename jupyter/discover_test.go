@@ -0,0 +1,45 @@
+package jupyter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFindPathSkipsNotExecutable(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), perm); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	exe := ""
+	if runtime.GOOS == "windows" {
+		exe = ".exe"
+	}
+	bad := write(filepath.Join("a", "jupyter"), 0644)
+	good := write(filepath.Join("b", "jupyter"+exe), 0755)
+	if err := os.MkdirAll(filepath.Join(dir, "c", "jupyter"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "d", "jupyter")
+
+	if path := findPath([]string{missing, bad, filepath.Join(dir, "c", "jupyter"), good}); path != good {
+		t.Errorf("findPath returned %q, want %q", path, good)
+	}
+	if path := findPath([]string{bad}); path != "" {
+		t.Errorf("findPath returned %q for a not executable file", path)
+	}
+	// the greatest match is not executable, the next one is taken
+	write(filepath.Join("v9", "jupyter"), 0644)
+	v1 := write(filepath.Join("v1", "jupyter"+exe), 0755)
+	if path := findPath([]string{filepath.Join(dir, "v*", "jupyter*")}); path != v1 {
+		t.Errorf("findPath returned %q, want %q", path, v1)
+	}
+}
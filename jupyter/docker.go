@@ -0,0 +1,126 @@
+package jupyter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// containerNotebookDir is where the notebook dir is mounted in the container,
+// the work dir of the jupyter docker stacks images.
+const containerNotebookDir = "/home/jovyan/work"
+
+// dockerPreflight checks the docker CLI instead of python and jupyter
+func (jl *JupyterLash) dockerPreflight() error {
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.genConfig {
+		return errors.New("generated config is not supported with docker")
+	}
+	if jl.sock != "" {
+		return errors.New("unix socket is not supported with docker")
+	}
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return fmt.Errorf("docker CLI is not found, it is required to run %s: %w", jl.dockerImage, err)
+	}
+	jl.dockerBin = docker
+	var ignored []string
+	if jl.venv != "" || jl.condaEnv != "" {
+		ignored = append(ignored, "venv")
+	}
+	if jl.install || jl.kernel {
+		ignored = append(ignored, "install")
+	}
+	if len(jl.pythonPath) > 0 {
+		ignored = append(ignored, "pythonpath")
+	}
+	if len(ignored) > 0 {
+		jl.logWarn("%s ignored with docker", strings.Join(ignored, ", "))
+	}
+	jl.log("docker: %s image: %s", jl.dockerBin, jl.dockerImage)
+	return nil
+}
+
+// dockerCommand returns the docker run command of jupyter in a container named
+// after the pid and the port, with the notebook dir mounted and the port published.
+// The resource limits are the limits of the container.
+func (jl *JupyterLash) dockerCommand() *exec.Cmd {
+	jl.container = fmt.Sprintf("neo-jupyter-%d-%d", os.Getpid(), jl.port)
+	port := strconv.Itoa(jl.port)
+	// docker takes a relative source as a volume name
+	notebookDir, err := filepath.Abs(jl.notebookDir)
+	if err != nil {
+		notebookDir = jl.notebookDir
+	}
+	args := []string{"run", "--rm", "--name", jl.container,
+		"-p", net.JoinHostPort(jl.bindIP, port) + ":" + port,
+		"-v", notebookDir + ":" + containerNotebookDir,
+	}
+	if jl.maxMemory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(jl.maxMemory, 10))
+	}
+	if jl.maxProcs > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(jl.maxProcs))
+	}
+	// the variables are passed by name, so that no values are in the docker args
+	env := jl.environ()
+	for _, k := range jl.containerEnvKeys(env) {
+		args = append(args, "-e", k)
+	}
+	if jl.certFile != "" && jl.keyFile != "" {
+		args = append(args, "-v", jl.certFile+":"+jl.certFile+":ro", "-v", jl.keyFile+":"+jl.keyFile+":ro")
+	}
+	args = append(args, jl.dockerImage, "jupyter")
+	args = append(args, jl.serverArgsFor(containerNotebookDir, "0.0.0.0")...)
+	args = append(args, jl.extraArgs...)
+	cmd := jl.execCommand(jl.ctx, jl.dockerBin, args...)
+	cmd.Env = env
+	return cmd
+}
+
+// interrupt stops proc gracefully, with docker stop if it runs in a container
+func (jl *JupyterLash) interrupt(proc *process) error {
+	proc.signaled.Store(true)
+	if proc.container == "" {
+		return interruptProcess(proc.cmd.Process, jl.stopSignal)
+	}
+	// docker stop blocks until the container exits, the caller waits for proc.done.
+	// it is called by both stop0 and the context cancel.
+	secs := strconv.Itoa(int(jl.shutdownTimeout.Seconds()))
+	proc.stopOnce.Do(func() {
+		go exec.Command(jl.dockerBin, "stop", "-t", secs, proc.container).Run()
+	})
+	return nil
+}
+
+// kill kills proc, and its container if it runs in one
+func (jl *JupyterLash) kill(proc *process) error {
+	proc.signaled.Store(true)
+	if proc.container != "" {
+		exec.Command(jl.dockerBin, "kill", proc.container).Run()
+	}
+	return killProcess(proc.cmd.Process)
+}
+
+// containerEnvKeys returns the keys of env forwarded to the container,
+// the machbase-neo, offline and extra variables, not the host paths of environ()
+func (jl *JupyterLash) containerEnvKeys(env []string) []string {
+	var keys []string
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		switch {
+		case strings.HasPrefix(k, envPrefix), hasEnv(jl.env, k), hasEnv(jl.fileEnv, k):
+		case jl.offline && hasEnv(offlineEnv, k):
+		default:
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package jupyter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvFile parses KEY=VALUE lines of a .env file into KEY=VALUE entries.
+// Empty lines and lines starting with # are skipped, and an optional export
+// prefix is allowed. A value can be double quoted with \n, \t, \" and \\ escapes,
+// single quoted as is, or unquoted with a trailing " # comment" removed.
+func ParseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv, err := parseEnvLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		env = append(env, kv)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+func parseEnvLine(line string) (string, error) {
+	line = strings.TrimPrefix(line, "export ")
+	k, v, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", fmt.Errorf("missing '=' in %q", line)
+	}
+	k = strings.TrimSpace(k)
+	if !envKeyRegexp.MatchString(k) {
+		return "", fmt.Errorf("invalid key %q", k)
+	}
+	v, err := parseEnvValue(strings.TrimSpace(v))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", k, err)
+	}
+	return k + "=" + v, nil
+}
+
+func parseEnvValue(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	var val, rest string
+	switch v[0] {
+	case '\'':
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		val, rest = v[1:end+1], v[end+2:]
+	case '"':
+		sb := strings.Builder{}
+		i := 1
+		for ; i < len(v) && v[i] != '"'; i++ {
+			if v[i] != '\\' || i+1 == len(v) {
+				sb.WriteByte(v[i])
+				continue
+			}
+			i++
+			switch v[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '"', '\\':
+				sb.WriteByte(v[i])
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(v[i])
+			}
+		}
+		if i == len(v) {
+			return "", fmt.Errorf("unterminated double quote")
+		}
+		val, rest = sb.String(), v[i+1:]
+	default:
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v), nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after the quoted value", rest)
+	}
+	return val, nil
+}
@@ -0,0 +1,65 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const drainCheckInterval = time.Second
+
+// busyKernels returns the number of kernels not idle, of all the kernels and of the sessions
+func (jl *JupyterLash) busyKernels() (busy int, kernels int, sessions int, err error) {
+	var list []struct {
+		ExecutionState string `json:"execution_state"`
+	}
+	if err := jl.getJSON(jl.apiURL("api/kernels"), &list); err != nil {
+		return 0, 0, 0, err
+	}
+	for _, k := range list {
+		if k.ExecutionState != "idle" {
+			busy++
+		}
+	}
+	var sess []json.RawMessage
+	if err := jl.getJSON(jl.apiURL("api/sessions"), &sess); err != nil {
+		return 0, 0, 0, err
+	}
+	return busy, len(list), len(sess), nil
+}
+
+// drain waits up to drainTimeout for all the kernels to be idle or no sessions,
+// so that restarting does not interrupt a running cell. It gives up early if
+// jupyter does not answer or Stop() is called, the restart goes on anyway.
+func (jl *JupyterLash) drain(stopC <-chan struct{}) {
+	busy, kernels, sessions, err := jl.busyKernels()
+	if err != nil {
+		jl.logWarn("fail to get the kernels, restarting without drain: %v", err)
+		return
+	}
+	jl.logEvent(levelInfo, map[string]any{"busy": busy, "kernels": kernels, "sessions": sessions},
+		"restarting with %d busy of %d kernels and %d sessions", busy, kernels, sessions)
+	if busy == 0 || sessions == 0 {
+		return
+	}
+	deadline := time.After(jl.drainTimeout)
+	tick := time.NewTicker(drainCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-deadline:
+			jl.logWarn("%d kernels are still busy after %s, restarting anyway", busy, jl.drainTimeout)
+			return
+		case <-tick.C:
+		}
+		if busy, _, sessions, err = jl.busyKernels(); err != nil {
+			jl.logWarn("fail to get the kernels, restarting: %v", err)
+			return
+		}
+		if busy == 0 || sessions == 0 {
+			jl.log("kernels are idle, restarting")
+			return
+		}
+	}
+}
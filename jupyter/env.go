@@ -0,0 +1,122 @@
+package jupyter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// envPrefix is the prefix of the machbase-neo environment variables
+// passed to jupyter, so that notebooks can reach the server.
+const envPrefix = "MACHBASE_NEO_"
+
+// environ returns the environment of the child processes,
+// built from os.Environ() with the venv activated and the extra env applied.
+func (jl *JupyterLash) environ() []string {
+	env := os.Environ()
+	if jl.venv != "" {
+		env = venvEnviron(jl.venv, env)
+	}
+	if jl.account != nil {
+		env = setEnv(env, "HOME="+jl.account.Home)
+		env = setEnv(env, "USER="+jl.account.Name)
+		env = setEnv(env, "LOGNAME="+jl.account.Name)
+	}
+	if jl.dataDir != "" {
+		env = setEnv(env, "JUPYTER_DATA_DIR="+jl.dataDir)
+	}
+	if jl.runtimeDir != "" {
+		env = setEnv(env, "JUPYTER_RUNTIME_DIR="+jl.runtimeDir)
+	}
+	if jl.offline {
+		for _, kv := range offlineEnv {
+			env = setEnv(env, kv)
+		}
+	}
+	for _, kv := range jl.fileEnv {
+		env = setEnv(env, kv)
+	}
+	for _, kv := range jl.env {
+		env = setEnv(env, kv)
+	}
+	if len(jl.pythonPath) > 0 {
+		paths := jl.pythonPath
+		if old := getEnv(env, "PYTHONPATH"); old != "" {
+			paths = append(paths[:len(paths):len(paths)], old)
+		}
+		env = setEnv(env, "PYTHONPATH="+strings.Join(paths, string(filepath.ListSeparator)))
+	}
+	return env
+}
+
+// auditEnv returns the machbase-neo, jupyter and the extra variables of env, secrets redacted
+func (jl *JupyterLash) auditEnv(env []string) []string {
+	var ret []string
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		switch {
+		case strings.HasPrefix(k, envPrefix), strings.HasPrefix(k, "JUPYTER_"), hasEnv(jl.env, k), hasEnv(jl.fileEnv, k):
+		case envKeyEqual(k, "PYTHONPATH") && len(jl.pythonPath) > 0:
+		default:
+			continue
+		}
+		ret = append(ret, jl.redactEnv(kv))
+	}
+	return ret
+}
+
+// setEnv sets kv in env, replacing the variable of the same key
+func setEnv(env []string, kv string) []string {
+	k, _, _ := strings.Cut(kv, "=")
+	ret := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		if ek, _, _ := strings.Cut(e, "="); !envKeyEqual(ek, k) {
+			ret = append(ret, e)
+		}
+	}
+	return append(ret, kv)
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if k, _, _ := strings.Cut(e, "="); envKeyEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func getEnv(env []string, key string) string {
+	for _, e := range env {
+		if k, v, _ := strings.Cut(e, "="); envKeyEqual(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func envKeyEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+var secretWords = []string{"TOKEN", "PASSWORD", "PASSWD", "SECRET", "KEY", "CREDENTIAL"}
+
+// redactEnv hides the value of kv if the key looks like a secret,
+// or it is in the env file, that is meant to keep secrets off the command line.
+func (jl *JupyterLash) redactEnv(kv string) string {
+	k, _, _ := strings.Cut(kv, "=")
+	if hasEnv(jl.fileEnv, k) {
+		return k + "=***"
+	}
+	upper := strings.ToUpper(k)
+	for _, w := range secretWords {
+		if strings.Contains(upper, w) {
+			return k + "=***"
+		}
+	}
+	return kv
+}
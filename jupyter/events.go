@@ -0,0 +1,44 @@
+package jupyter
+
+import "time"
+
+type EventType string
+
+const (
+	EventStarting   EventType = "starting"   // the process is being launched
+	EventRunning    EventType = "running"    // the server url is printed, URL is set
+	EventExited     EventType = "exited"     // the process exited, ExitCode is set
+	EventRestarting EventType = "restarting" // a restart is scheduled after a crash, ExitCode is of the crash
+	EventFailed     EventType = "failed"     // exited and not restarted, no more events
+)
+
+// Event is a lifecycle event of jupyter.
+//
+// For each launch, events are published in the order of Starting, Running
+// and Exited, Running is skipped if it exits before printing the url.
+// Exited is followed by Restarting when the supervisor restarts it,
+// then by Starting of the next launch, or by Failed when it gives up.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Pid      int
+	ExitCode int
+	URL      string
+	Err      error
+}
+
+const eventBufferSize = 64
+
+// Events returns the channel of lifecycle events. Events are published
+// without blocking the supervisor, they are dropped when the buffer is full.
+func (jl *JupyterLash) Events() <-chan Event {
+	return jl.events
+}
+
+func (jl *JupyterLash) publish(evt Event) {
+	evt.Time = time.Now()
+	select {
+	case jl.events <- evt:
+	default:
+	}
+}
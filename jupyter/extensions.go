@@ -0,0 +1,113 @@
+package jupyter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// labExtensionStates returns the installed lab extensions, and the disabled
+// extensions and plugins, parsed from jupyter labextension list, e.g.
+//
+//	@jupyter-widgets/jupyterlab-manager v5.0.9 enabled OK (python, jupyterlab_widgets)
+//	Disabled extensions:
+//	    @jupyterlab/apputils-extension:announcements
+func (jl *JupyterLash) labExtensionStates() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, "-m", "jupyter", "labextension", "list").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("jupyter labextension list: %w", err)
+	}
+	states := map[string]bool{}
+	disabledSection := false
+	s := bufio.NewScanner(strings.NewReader(string(out)))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		switch {
+		case len(f) == 0:
+			disabledSection = false
+		case strings.HasPrefix(s.Text(), "Disabled extensions:"):
+			disabledSection = true
+		case disabledSection:
+			states[f[0]] = false
+		case len(f) >= 3 && (f[2] == "enabled" || f[2] == "disabled"):
+			states[f[0]] = f[2] == "enabled"
+		}
+	}
+	return states, nil
+}
+
+// configureExtensions enables and disables the lab extensions, skipping the
+// ones already in the state. A missing extension to enable is installed with
+// pip if install is enabled, or returned as missing if offline.
+func (jl *JupyterLash) configureExtensions() ([]string, error) {
+	if len(jl.enableExts) == 0 && len(jl.disableExts) == 0 {
+		return nil, nil
+	}
+	if jl.ui == "server" {
+		jl.logWarn("lab extensions have no effect with ui %q", jl.ui)
+		return nil, nil
+	}
+	states, err := jl.labExtensionStates()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, ext := range jl.enableExts {
+		name, pkg, ok := strings.Cut(ext, "=")
+		if !ok {
+			pkg = name
+		}
+		enabled, installed := states[name]
+		if !installed && strings.Contains(name, ":") {
+			// a plugin id, not listed unless disabled
+			enabled, installed = true, true
+		}
+		if !installed {
+			switch {
+			case jl.offline:
+				missing = append(missing, pkg)
+				continue
+			case jl.install:
+				jl.log("lab extension %s is not installed, installing %s...", name, pkg)
+				if err := jl.pipInstall(pkg); err != nil {
+					return nil, fmt.Errorf("fail to install %s: %v", pkg, err)
+				}
+			default:
+				jl.logWarn("lab extension %s is not installed", name)
+				continue
+			}
+		}
+		if installed && enabled {
+			jl.log("lab extension %s: enabled", name)
+			continue
+		}
+		if err := jl.labExtension("enable", name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range jl.disableExts {
+		if enabled, ok := states[name]; ok && !enabled {
+			jl.log("lab extension %s: disabled", name)
+			continue
+		}
+		if err := jl.labExtension("disable", name); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// labExtension runs jupyter labextension enable or disable of name
+func (jl *JupyterLash) labExtension(op string, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, "-m", "jupyter", "labextension", op, name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("jupyter labextension %s %s: %w: %s", op, name, err, strings.TrimSpace(string(out)))
+	}
+	jl.log("lab extension %s: %sd", name, op)
+	return nil
+}
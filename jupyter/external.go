@@ -0,0 +1,158 @@
+package jupyter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// externalCheckInterval is the health check interval of the external jupyter
+// if the health check is not configured.
+const externalCheckInterval = 10 * time.Second
+
+// ParseExternalURL validates the url of an external jupyter, e.g. http://127.0.0.1:8888/base/
+func ParseExternalURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid scheme %q, must be http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("no host")
+	}
+	return u, nil
+}
+
+// monitor0 starts monitoring the external jupyter instead of launching one,
+// the caller must hold the lock.
+func (jl *JupyterLash) monitor0() error {
+	if jl.monitoring {
+		return nil
+	}
+	u, err := ParseExternalURL(jl.externalURL)
+	if err != nil {
+		return fmt.Errorf("invalid external url: %w", err)
+	}
+	if t := u.Query().Get("token"); t != "" && jl.token == "" {
+		jl.token = t
+	}
+	jl.external = u
+	jl.monitoring = true
+	jl.log("monitoring external jupyter: %s", jl.redactURL(u))
+	jl.status.update(func(st *Status) {
+		st.Pid = os.Getpid()
+		st.JupyterPid = 0
+		st.Bind = u.Hostname()
+		st.Port = jl.externalPort()
+		st.BaseURL = u.Path
+		st.URL = u.String()
+		st.Token = jl.token
+		st.External = true
+		st.StartTime = time.Now()
+		st.State = StateStarting
+	})
+	go jl.externalLoop(jl.stopC)
+	return nil
+}
+
+// externalLoop checks api/status of the external jupyter until Stop() is called,
+// the state is running while it responds and unreachable after healthRetries failures.
+func (jl *JupyterLash) externalLoop(stopC <-chan struct{}) {
+	interval := jl.healthInterval
+	if interval <= 0 {
+		interval = externalCheckInterval
+	}
+	statusURL := jl.apiURL("api/status")
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	failures := 0
+	for {
+		err := jl.checkHealth(statusURL)
+		// Stop() closes stopC with the lock held, no update after the status file is removed
+		jl.RLock()
+		select {
+		case <-stopC:
+			jl.RUnlock()
+			return
+		default:
+		}
+		state := jl.State()
+		if err == nil {
+			failures = 0
+			jl.unhealthy.Store(false)
+			if state != StateRunning {
+				jl.logEvent(levelInfo, map[string]any{"url": jl.externalURL}, "external jupyter is up")
+				jl.setState(StateRunning)
+				jl.publish(Event{Type: EventRunning, URL: jl.external.String()})
+			}
+		} else {
+			failures++
+			if state == StateUnreachable {
+				jl.logDebug("health check failed: %v", err)
+			} else {
+				jl.logError("health check failed (%d/%d): %v", failures, jl.healthRetries, err)
+			}
+			if failures >= jl.healthRetries && state != StateUnreachable {
+				jl.unhealthy.Store(true)
+				jl.logError("external jupyter is unreachable")
+				jl.setState(StateUnreachable)
+			}
+		}
+		jl.RUnlock()
+		select {
+		case <-stopC:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// waitExternal waits until the external jupyter responds
+func (jl *JupyterLash) waitExternal(timeout time.Duration) error {
+	stopC := jl.Stopped()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for jl.State() != StateRunning {
+		select {
+		case <-stopC:
+			return errors.New("stopped before the external jupyter is ready")
+		case <-deadline.C:
+			return fmt.Errorf("external jupyter is not ready in %s", timeout)
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// externalAPIURL returns the url of api relative to the path of the external url
+func (jl *JupyterLash) externalAPIURL(api string) string {
+	return fmt.Sprintf("%s://%s%s", jl.external.Scheme, jl.external.Host, joinURLPath(jl.external.Path, api))
+}
+
+func (jl *JupyterLash) externalPort() int {
+	if p := jl.external.Port(); p != "" {
+		var port int
+		fmt.Sscan(p, &port)
+		return port
+	}
+	if jl.external.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// redactURL returns u with the token in the query redacted
+func (jl *JupyterLash) redactURL(u *url.URL) string {
+	s := u.String()
+	if jl.token != "" {
+		s = strings.ReplaceAll(s, jl.token, "***")
+	}
+	return s
+}
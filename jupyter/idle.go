@@ -0,0 +1,99 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const idleCheckIntervalMax = 30 * time.Second
+
+// noActivitySetting makes jupyter shut itself down after no activity for the seconds
+const noActivitySetting = "ServerApp.shutdown_no_activity_timeout"
+
+// noActivityRegexp matches the log of jupyter server shutting down by noActivitySetting
+var noActivityRegexp = regexp.MustCompile(`No kernels for \d+ seconds`)
+
+// scanNoActivity marks the process shutting down by noActivitySetting,
+// so that its exit is not taken as a crash and is not restarted.
+func (jl *JupyterLash) scanNoActivity(line string) {
+	if t := jl.settings[noActivitySetting]; t == "" || t == "0" || !noActivityRegexp.MatchString(line) {
+		return
+	}
+	if !jl.noActivity.Swap(true) {
+		jl.log("jupyter is shutting down after no activity for %ss", jl.settings[noActivitySetting])
+	}
+}
+
+// idleLoop stops jupyter when it has no kernels and no sessions for idleTimeout.
+// It does not count the time before jupyter is ready.
+func (jl *JupyterLash) idleLoop(exitC <-chan struct{}, stopC <-chan struct{}) {
+	interval := min(jl.idleTimeout/10, idleCheckIntervalMax)
+	if interval < time.Second {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var idleSince time.Time
+	for {
+		select {
+		case <-exitC:
+			return
+		case <-stopC:
+			return
+		case <-tick.C:
+		}
+		if jl.State() != StateRunning {
+			idleSince = time.Time{}
+			continue
+		}
+		active, err := jl.activeCount()
+		if err != nil || active > 0 {
+			idleSince = time.Time{}
+			continue
+		}
+		if idleSince.IsZero() {
+			idleSince = time.Now()
+			continue
+		}
+		if time.Since(idleSince) >= jl.idleTimeout {
+			jl.log("no active kernels or sessions for %s, stopping jupyter", jl.idleTimeout)
+			jl.Stop()
+			return
+		}
+	}
+}
+
+// activeCount returns the number of kernels and sessions of jupyter
+func (jl *JupyterLash) activeCount() (int, error) {
+	count := 0
+	for _, api := range []string{"api/kernels", "api/sessions"} {
+		var list []json.RawMessage
+		if err := jl.getJSON(jl.apiURL(api), &list); err != nil {
+			return 0, err
+		}
+		count += len(list)
+	}
+	return count, nil
+}
+
+func (jl *JupyterLash) getJSON(u string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if jl.token != "" {
+		req.Header.Set("Authorization", "token "+jl.token)
+	}
+	rsp, err := jl.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", rsp.Status)
+	}
+	return json.NewDecoder(rsp.Body).Decode(v)
+}
@@ -0,0 +1,1139 @@
+// Package jupyter launches and supervises a jupyter lab server.
+package jupyter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// DefaultBaseURL is the base url where machbase-neo proxies jupyter.
+const DefaultBaseURL = "/web/apps/neo-jupyter/base/"
+
+// uis are the jupyter subcommands with the python module and the pip package
+var uis = map[string]struct {
+	module string
+	pkg    string
+}{
+	"lab":      {"jupyterlab", "jupyterlab"},
+	"notebook": {"notebook", "notebook"},
+	"server":   {"jupyter_server", "jupyter-server"},
+}
+
+// JupyterLash launches and supervises a jupyter lab server.
+type JupyterLash struct {
+	sync.RWMutex
+	pythonBin   string
+	jupyterBin  string
+	notebookDir string
+	baseURL     string
+	ui          string
+	port        int
+	portAuto    bool
+	portRange   int
+	portFile    string
+	bindIP      string
+	allowRemote bool
+	token       string
+	certFile    string
+	keyFile     string
+	restart     bool
+	restartMax  int
+	stopping    bool
+	stopC       chan struct{}
+	failC       chan struct{}
+	killC       chan struct{} // closed by Kill()
+	killOnce    sync.Once
+	failures    int
+	backoff     time.Duration
+	startedAt   time.Time
+	ctx         context.Context
+	events      chan Event
+	proc        *process // nil if not running
+	// sock is the unix socket jupyter listens on instead of the port if not empty
+	sock           string
+	sockClient     *http.Client
+	sockClientOnce sync.Once
+	// dockerImage is run with docker instead of the local python if not empty
+	dockerImage string
+	dockerBin   string
+	container   string // name of the last container
+	// externalURL is monitored instead of launching jupyter if not empty
+	externalURL   string
+	external      *url.URL
+	monitoring    bool
+	exitCode      int // exit code of the last process
+	lg            Logger
+	jupyterLg     Logger // logger of jupyter output, default is lg
+	discardStdout bool   // jupyter's stdout is scanned but not logged
+	urlScanner    serverURLScanner
+	openURL       bool // open the server url in the browser when ready
+	openOnce      sync.Once
+	readyW        io.Writer // the ready line is written to if not nil
+	readyOnce     sync.Once
+	status        statusFile
+	// execCommand creates the commands of python, it can be replaced
+	// with a fake to run a helper process instead of python in tests.
+	execCommand func(ctx context.Context, name string, args ...string) *exec.Cmd
+
+	shutdownTimeout  time.Duration
+	stopSignal       syscall.Signal // first signal to stop jupyter, killed after shutdownTimeout
+	startupTimeout   time.Duration
+	discoveryTimeout time.Duration
+
+	healthInterval time.Duration
+	healthRetries  int
+	unhealthy      atomic.Bool // health check failed healthRetries times in a row
+	portInUse      atomic.Bool // jupyter failed to listen on the port
+	idleTimeout    time.Duration
+	usageInterval  time.Duration
+	usage          atomic.Pointer[Usage] // last sample, nil if not sampling
+
+	// maxMemory and maxProcs are RLIMIT_AS and RLIMIT_NPROC of jupyter on linux, 0 is unlimited
+	maxMemory int64
+	maxProcs  int
+	limitHit  atomic.Bool // jupyter output reported an exceeded limit
+	// noActivity is set when jupyter shuts itself down by shutdown_no_activity_timeout
+	noActivity atomic.Bool
+
+	metricsAddr string
+	metricsSrv  *http.Server
+	adminAddr   string
+	adminToken  string
+	adminSrv    *http.Server
+	restarts    int
+	// reportURL is posted the heartbeat every reportInterval if not empty
+	reportURL      string
+	reportInterval time.Duration
+	reportDone     chan struct{} // closed when reportLoop returned
+
+	venv     string
+	condaBin string
+	condaEnv string
+
+	pythonVersion string
+	uiVersion     string
+	// pythonDiscovered is set if python is not given, an old one is rejected then
+	pythonDiscovered bool
+
+	install        bool
+	pipIndex       string
+	installTimeout time.Duration
+	kernel         bool
+	requirements   string // requirements file, default is requirements.txt in the notebook dir
+	offline        bool   // never install, and pip of jupyter uses no index
+	collaborative  bool   // real-time collaboration
+	// enableExts and disableExts are lab extensions or plugins, enableExts
+	// may be name=pkg to install pkg with pip if name is not installed.
+	enableExts  []string
+	disableExts []string
+
+	// settings are rendered as --<key>=<value> in sorted order
+	settings map[string]string
+	// genConfig renders the settings to configDir instead of the command line
+	genConfig  bool
+	keepConfig bool
+	configDir  string
+	// dataDir and runtimeDir are JUPYTER_DATA_DIR and JUPYTER_RUNTIME_DIR if not empty
+	dataDir    string
+	runtimeDir string
+	// pythonPath is prepended to PYTHONPATH of jupyter
+	pythonPath []string
+	// env is KEY=VALUE added to the environment of jupyter
+	env []string
+	// fileEnv is KEY=VALUE of the env file, applied before env and redacted in the logs
+	fileEnv []string
+	// extraArgs are appended after the built-in arguments,
+	// so that the later one wins when jupyter parses them.
+	extraArgs []string
+	// account runs python and jupyter as another user if not nil
+	account *Account
+	// skipChecks skips the notebook dir write check of Preflight and Reload
+	skipChecks bool
+	// reuse adopts the jupyter of the status file instead of launching one, see reusable
+	reuse bool
+	// kernelLogs writes the lines of each kernel to its own file if not nil
+	kernelLogs      *kernelLogs
+	kernelLogFailed atomic.Bool
+	// drainTimeout bounds the wait of Restart for the kernels to be idle, 0 does not wait
+	drainTimeout time.Duration
+}
+
+// process is a launched jupyter, done is closed when it exited
+type process struct {
+	cmd       *exec.Cmd
+	container string      // docker container name if it runs in docker
+	signaled  atomic.Bool // interrupted or killed by neo-jupyter
+	stopOnce  sync.Once
+	done      chan struct{}
+	exitCode  int
+	err       error // error of the unexpected exit
+}
+
+func New(opts ...Option) *JupyterLash {
+	jl := &JupyterLash{
+		notebookDir:      ".",
+		baseURL:          DefaultBaseURL,
+		ui:               "lab",
+		port:             8888,
+		portRange:        100,
+		bindIP:           "127.0.0.1",
+		allowRemote:      true,
+		shutdownTimeout:  5 * time.Second,
+		stopSignal:       syscall.SIGTERM,
+		discoveryTimeout: 10 * time.Second,
+		healthRetries:    3,
+		installTimeout:   10 * time.Minute,
+		settings:         map[string]string{},
+		events:           make(chan Event, eventBufferSize),
+		killC:            make(chan struct{}),
+		execCommand:      exec.CommandContext,
+		ctx:              context.Background(),
+	}
+	jl.status.status.State = StateStopped
+	for _, o := range opts {
+		o(jl)
+	}
+	return jl
+}
+
+func (jl *JupyterLash) Start() error {
+	return jl.StartContext(context.Background())
+}
+
+// StartContext starts jupyter that is stopped when ctx is done,
+// gracefully first and killed after the shutdown timeout.
+// If the startup timeout is set, it waits until jupyter is ready,
+// and it is a failed start if not ready in time.
+// systemd is notified READY=1 after that if NOTIFY_SOCKET is set.
+func (jl *JupyterLash) StartContext(ctx context.Context) error {
+	jl.Lock()
+	if jl.proc != nil || jl.monitoring {
+		jl.Unlock()
+		return nil
+	}
+	jl.ctx = ctx
+	if err := jl.startMetrics(); err != nil {
+		jl.Unlock()
+		jl.Stop()
+		return err
+	}
+	if err := jl.startAdmin(); err != nil {
+		jl.Unlock()
+		jl.Stop()
+		return err
+	}
+	if err := jl.start1(); err != nil {
+		jl.Unlock()
+		jl.Stop()
+		return err
+	}
+	if jl.reportURL != "" && jl.reportDone == nil {
+		jl.reportDone = make(chan struct{})
+		go jl.reportLoop(jl.stopC, jl.reportDone)
+	}
+	if done := ctx.Done(); done != nil {
+		go func(stopC <-chan struct{}) {
+			select {
+			case <-done:
+				// no restart after the context is done
+				jl.Stop()
+			case <-stopC:
+			}
+		}(jl.stopC)
+	}
+	proc := jl.proc
+	jl.Unlock()
+
+	if jl.startupTimeout > 0 {
+		var err error
+		if jl.externalURL != "" {
+			err = jl.waitExternal(jl.startupTimeout)
+		} else {
+			err = jl.waitReady(proc, jl.startupTimeout)
+		}
+		if err != nil {
+			jl.Stop()
+			return err
+		}
+		jl.writeReady(jl.ServerURL())
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		jl.logError("fail to notify systemd: %v", err)
+	}
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go jl.watchdogLoop(interval, jl.Stopped())
+	}
+	return nil
+}
+
+// waitReady waits until jupyter prints the server url or responds to api/status
+func (jl *JupyterLash) waitReady(proc *process, timeout time.Duration) error {
+	if proc == nil {
+		return errors.New("jupyter is not running")
+	}
+	statusURL := jl.apiURL("api/status")
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if jl.ServerURL() != "" || jl.checkHealth(statusURL) == nil {
+			return nil
+		}
+		select {
+		case <-proc.done:
+			if proc.err != nil {
+				return proc.err
+			}
+			return fmt.Errorf("jupyter exited with %d before ready", proc.exitCode)
+		case <-deadline.C:
+			return fmt.Errorf("jupyter is not ready in %s", timeout)
+		case <-tick.C:
+		}
+	}
+}
+
+// Restart stops jupyter and waits for it to exit, then starts it again
+// with the same configuration. It just starts if jupyter is not running.
+// With WithDrain it first waits for the kernels to be idle, see drain.
+func (jl *JupyterLash) Restart() error {
+	jl.Lock()
+	running, stopC := jl.proc != nil, jl.stopC
+	jl.Unlock()
+	if running && jl.drainTimeout > 0 {
+		jl.drain(stopC)
+	}
+	jl.Lock()
+	defer jl.Unlock()
+	if err := jl.stop0(); err != nil {
+		jl.logError("%v", err)
+	}
+	return jl.restart1()
+}
+
+// restart1 starts jupyter again after stop0, the caller must hold the lock.
+// Nothing is running to be supervised if it fails, so it is a failure of jupyter.
+func (jl *JupyterLash) restart1() error {
+	jl.restarts++
+	if err := jl.start1(); err != nil {
+		jl.exitCode = -1
+		jl.fail0(-1)
+		return err
+	}
+	return nil
+}
+
+// Reload restarts jupyter with the notebook dir and the settings replaced.
+// The running jupyter is kept if the new notebook dir is not writable.
+// It is a failure of jupyter if it does not start again, the same as Restart.
+func (jl *JupyterLash) Reload(notebookDir string, settings map[string]string) error {
+	jl.Lock()
+	defer jl.Unlock()
+	if !jl.skipChecks {
+		if err := CheckWritable(notebookDir); err != nil {
+			return err
+		}
+	}
+	if err := jl.stop0(); err != nil {
+		jl.logError("%v", err)
+	}
+	jl.notebookDir = notebookDir
+	jl.settings = maps.Clone(settings)
+	return jl.restart1()
+}
+
+// start1 prepares and starts jupyter, the caller must hold the lock
+func (jl *JupyterLash) start1() error {
+	if jl.proc != nil {
+		return nil
+	}
+	if jl.stopC == nil || jl.stopping {
+		// Restart() keeps stopC, it is closed only by Stop()
+		jl.stopC = make(chan struct{})
+	}
+	jl.stopping = false
+	if jl.failC == nil {
+		jl.failC = make(chan struct{})
+	}
+	if jl.externalURL != "" {
+		return jl.monitor0()
+	}
+	if ip := net.ParseIP(jl.bindIP); ip != nil && !ip.IsLoopback() && jl.token == "" {
+		jl.logWarn("listening on %s, token authentication is disabled", jl.bindIP)
+	}
+	for _, arg := range append(jl.settingArgs(), jl.extraArgs...) {
+		if name := managedArgName(arg); name != "" {
+			jl.logWarn("jupyter argument %q overrides %s set by neo-jupyter", arg, name)
+		}
+	}
+	// before the port is changed by port auto, or the jupyter is reaped as an orphan
+	if jl.reuse && jl.sock == "" && jl.dockerImage == "" {
+		st, err := jl.reusable()
+		if err == nil {
+			return jl.reuse0(st)
+		}
+		if errors.Is(err, errNoPrevious) {
+			jl.log("no jupyter to reuse, launching a new one")
+		} else {
+			jl.log("not reusing jupyter pid %d, %v, launching a new one", st.JupyterPid, err)
+		}
+	}
+	if jl.sock != "" {
+		if err := jl.prepareSocket(); err != nil {
+			return err
+		}
+	} else if jl.portAuto {
+		port, err := findFreePort(jl.bindIP, jl.port, jl.portRange)
+		if err != nil {
+			return fmt.Errorf("fail to find free port: %w", err)
+		}
+		if port != jl.port {
+			jl.log("port %d is in use, using %d", jl.port, port)
+			jl.port = port
+		}
+	}
+	if jl.portFile != "" && jl.sock == "" {
+		if err := os.WriteFile(jl.portFile, []byte(strconv.Itoa(jl.port)), 0644); err != nil {
+			jl.logError("fail to write port file: %v", err)
+		}
+	}
+	// the token is not logged, it is in the status file and Token()
+	if jl.token != "" {
+		jl.log("jupyter token: set")
+	}
+	jl.log("jupyter url: %s", jl.url())
+	reapOrphan(jl.status.path, hasParentDeathSignal && !jl.reuse, jl.logger())
+	jl.status.update(func(st *Status) {
+		st.Pid = os.Getpid()
+		st.Port = jl.port
+		st.Bind = jl.bindIP
+		st.Sock = jl.sock
+		if jl.sock != "" {
+			st.Port, st.Bind = 0, ""
+		}
+		st.BaseURL = jl.baseURL
+		st.Token = jl.token
+	})
+	return jl.start0()
+}
+
+func (jl *JupyterLash) Stop() error {
+	jl.Lock()
+	if !jl.stopping && jl.stopC != nil {
+		close(jl.stopC)
+		sdNotify("STOPPING=1")
+	}
+	jl.stopping = true
+	err := jl.stop0()
+	if jl.monitoring {
+		// the external jupyter is not ours, just stop monitoring it
+		jl.monitoring = false
+		jl.setState(StateStopped)
+	}
+	jl.status.remove()
+	jl.removeServerConfig()
+	jl.removeSocket()
+	srvs := []*http.Server{jl.metricsSrv, jl.adminSrv}
+	jl.metricsSrv, jl.adminSrv = nil, nil
+	reportDone := jl.reportDone
+	jl.reportDone = nil
+	jl.Unlock()
+	for _, srv := range srvs {
+		shutdownServer(srv)
+	}
+	// the last heartbeat of the stopped state
+	if reportDone != nil {
+		<-reportDone
+	}
+	return err
+}
+
+// Kill makes the pending and the later Stop() kill jupyter immediately
+// instead of waiting for the graceful shutdown. It does not wait for the lock,
+// so it can be called while Stop() is waiting.
+func (jl *JupyterLash) Kill() {
+	jl.killOnce.Do(func() { close(jl.killC) })
+}
+
+// Stopped returns a channel that is closed when Stop() is called,
+// by the caller or by the idle timeout.
+func (jl *JupyterLash) Stopped() <-chan struct{} {
+	jl.RLock()
+	defer jl.RUnlock()
+	return jl.stopC
+}
+
+// Failed returns a channel that is closed when jupyter exited by itself
+// and is not restarted, or has been restarted more than restartMax times in a row.
+// ExitCode() is the exit code of jupyter then.
+func (jl *JupyterLash) Failed() <-chan struct{} {
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.failC == nil {
+		jl.failC = make(chan struct{})
+	}
+	return jl.failC
+}
+
+func (jl *JupyterLash) scheme() string {
+	if jl.certFile != "" && jl.keyFile != "" {
+		return "https"
+	}
+	return "http"
+}
+
+func (jl *JupyterLash) url() string {
+	if jl.sock != "" {
+		return jl.sockURL()
+	}
+	return fmt.Sprintf("%s://%s%s", jl.scheme(), net.JoinHostPort(jl.bindIP, strconv.Itoa(jl.port)), jl.baseURL)
+}
+
+// localHost returns the address to reach jupyter from this host
+func (jl *JupyterLash) localHost() string {
+	if ip := net.ParseIP(jl.bindIP); ip != nil && ip.IsUnspecified() {
+		if ip.To4() != nil {
+			return "127.0.0.1"
+		}
+		return "::1"
+	}
+	return jl.bindIP
+}
+
+func (jl *JupyterLash) apiURL(api string) string {
+	if jl.external != nil {
+		return jl.externalAPIURL(api)
+	}
+	if jl.sock != "" {
+		// the host is ignored by the unix socket client
+		return "http://localhost" + joinURLPath(jl.baseURL, api)
+	}
+	return fmt.Sprintf("%s://%s%s", jl.scheme(), net.JoinHostPort(jl.localHost(), strconv.Itoa(jl.port)), joinURLPath(jl.baseURL, api))
+}
+
+func joinURLPath(base string, elem string) string {
+	base = strings.Trim(base, "/")
+	elem = strings.TrimLeft(elem, "/")
+	if base == "" {
+		return "/" + elem
+	}
+	return "/" + base + "/" + elem
+}
+
+var healthClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		// jupyter may serve a self-signed certificate
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+func (jl *JupyterLash) checkHealth(statusURL string) error {
+	req, err := http.NewRequest(http.MethodGet, statusURL, nil)
+	if err != nil {
+		return err
+	}
+	if jl.token != "" {
+		req.Header.Set("Authorization", "token "+jl.token)
+	}
+	rsp, err := jl.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", rsp.Status)
+	}
+	return nil
+}
+
+// healthLoop polls api/status until the process exits or Stop() is called,
+// and kills the process after healthRetries consecutive failures
+// so that the supervisor restarts it. Failures are not counted until jupyter
+// is ready, by the server url or the first successful check, as it is still booting.
+func (jl *JupyterLash) healthLoop(proc *process, stopC <-chan struct{}) {
+	statusURL := jl.apiURL("api/status")
+	tick := time.NewTicker(jl.healthInterval)
+	defer tick.Stop()
+	failures := 0
+	ready := false
+	for {
+		select {
+		case <-proc.done:
+			return
+		case <-stopC:
+			return
+		case <-tick.C:
+		}
+		err := jl.checkHealth(statusURL)
+		if !ready && err != nil && jl.State() != StateRunning {
+			continue
+		}
+		ready = true
+		if err != nil {
+			failures++
+			jl.logError("health check failed (%d/%d): %v", failures, jl.healthRetries, err)
+		} else {
+			failures = 0
+			jl.unhealthy.Store(false)
+			jl.portInUse.Store(false)
+			continue
+		}
+		if failures >= jl.healthRetries {
+			jl.unhealthy.Store(true)
+			if !jl.restart {
+				failures = 0
+				continue
+			}
+			jl.logError("jupyter lab is unhealthy, killing pid %d", proc.cmd.Process.Pid)
+			jl.kill(proc)
+			return
+		}
+	}
+}
+
+// pythonCommand returns a command running python with args in the configured environment
+func (jl *JupyterLash) pythonCommand(ctx context.Context, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if jl.condaEnv != "" {
+		cmd = jl.execCommand(ctx, jl.condaBin, append([]string{"run", "--no-capture-output", "-n", jl.condaEnv, "python"}, args...)...)
+	} else {
+		cmd = jl.execCommand(ctx, jl.pythonBin, args...)
+	}
+	cmd.Env = jl.environ()
+	jl.applyAccount(cmd)
+	return cmd
+}
+
+// command returns the jupyter command, with the generated config written if enabled.
+// With dryRun nothing is written, the config dir is a placeholder if not created yet.
+func (jl *JupyterLash) command(dryRun bool) (*exec.Cmd, error) {
+	if jl.dockerImage != "" {
+		return jl.dockerCommand(), nil
+	}
+	jl.container = ""
+	var args []string
+	var configDir string
+	if jl.genConfig {
+		if dryRun {
+			configDir = jl.configDir
+			if configDir == "" {
+				configDir = filepath.Join(os.TempDir(), "neo-jupyter-config-*")
+			}
+		} else {
+			dir, err := jl.writeServerConfig()
+			if err != nil {
+				return nil, fmt.Errorf("fail to write jupyter config: %w", err)
+			}
+			configDir = dir
+		}
+		args = []string{jl.ui, "-y", "--no-browser"}
+	} else {
+		args = jl.serverArgs()
+	}
+	// jupyter refuses to run as root without it
+	if jl.runsAsRoot() {
+		args = append(args, "--allow-root")
+	}
+	args = append(args, jl.extraArgs...)
+	var cmd *exec.Cmd
+	if jl.condaEnv != "" || jl.jupyterBin == "" {
+		cmd = jl.pythonCommand(jl.ctx, append([]string{"-m", "jupyter"}, args...)...)
+	} else {
+		cmd = jl.pythonCommand(jl.ctx, append([]string{jl.jupyterBin}, args...)...)
+	}
+	// relative imports of the notebooks do not depend on the cwd of the launcher
+	cmd.Dir = jl.notebookDir
+	if configDir != "" {
+		cmd.Env = setEnv(cmd.Env, "JUPYTER_CONFIG_DIR="+configDir)
+		jl.log("jupyter config: %s", filepath.Join(configDir, serverConfigFile))
+	}
+	return cmd, nil
+}
+
+// DryRun prints the jupyter command and its environment sorted by key
+// to w with the secrets redacted, instead of starting jupyter. It writes no files.
+func (jl *JupyterLash) DryRun(w io.Writer) error {
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.externalURL != "" {
+		u, err := ParseExternalURL(jl.externalURL)
+		if err != nil {
+			return fmt.Errorf("invalid external url: %w", err)
+		}
+		fmt.Fprintf(w, "external: %s\n", jl.redactURL(u))
+		return nil
+	}
+	cmd, err := jl.command(true)
+	if err != nil {
+		return err
+	}
+	args, env := jl.redactCommand(cmd)
+	fmt.Fprintf(w, "command: %s\n", strings.Join(args, " "))
+	if cmd.Dir != "" {
+		fmt.Fprintf(w, "dir: %s\n", cmd.Dir)
+	}
+	fmt.Fprintln(w, "env:")
+	for _, kv := range env {
+		fmt.Fprintf(w, "  %s\n", kv)
+	}
+	return nil
+}
+
+// redactCommand returns the args and the environment sorted by key of cmd, secrets redacted
+func (jl *JupyterLash) redactCommand(cmd *exec.Cmd) ([]string, []string) {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		if jl.token != "" {
+			arg = strings.ReplaceAll(arg, jl.token, "***")
+		}
+		args[i] = arg
+	}
+	env := make([]string, len(cmd.Env))
+	for i, kv := range cmd.Env {
+		env[i] = jl.redactEnv(kv)
+	}
+	sort.Strings(env)
+	return args, env
+}
+
+func (jl *JupyterLash) start0() error {
+	cmd, err := jl.command(false)
+	if err != nil {
+		return err
+	}
+	if env := jl.auditEnv(cmd.Env); len(env) > 0 {
+		jl.log("jupyter env: %s", strings.Join(env, " "))
+	}
+	if jl.runsAsRoot() {
+		jl.logWarn("running jupyter as root with --allow-root, the notebooks, kernels and terminals have full control of this system. Run it as an unprivileged user instead")
+	}
+	if jl.debugEnabled() {
+		args, env := jl.redactCommand(cmd)
+		jl.logDebug("jupyter command: %s", strings.Join(args, " "))
+		jl.logDebug("jupyter full env: %s", strings.Join(env, " "))
+	}
+	jl.urlScanner.reset(jl.baseURL, jl.token)
+	jl.unhealthy.Store(false)
+	jl.portInUse.Store(false)
+	jl.limitHit.Store(false)
+	jl.noActivity.Store(false)
+	jl.setState(StateStarting)
+	jl.publish(Event{Type: EventStarting})
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	// graceful shutdown when the context is done, and kill after the timeout.
+	// it also bounds waiting for kernels that inherited the output pipes.
+	proc := &process{cmd: cmd, container: jl.container, done: make(chan struct{})}
+	cmd.Cancel = func() error { return jl.interrupt(proc) }
+	cmd.WaitDelay = jl.shutdownTimeout
+	cmd.Stdin = os.Stdin
+	// the parent death signal would stop the jupyter for the next one to reuse
+	setProcAttr(cmd, !jl.reuse)
+	if proc.container == "" {
+		jl.applyAccount(cmd)
+	}
+	captureWg := sync.WaitGroup{}
+	captureWg.Add(2)
+	go func() {
+		defer captureWg.Done()
+		forwardLines(stdoutR, jl.logJupyter)
+	}()
+	go func() {
+		defer captureWg.Done()
+		forwardLines(stderrR, jl.logJupyterError)
+	}()
+	closeCapture := func() {
+		stdoutW.Close()
+		stderrW.Close()
+		captureWg.Wait()
+	}
+	// start synchronously, so that jl.proc is set when Start() returns
+	if err := cmd.Start(); err != nil {
+		closeCapture()
+		jl.setState(StateStopped)
+		err = fmt.Errorf("fail to start: cmd:%q error:%w", cmd.Path, err)
+		jl.publish(Event{Type: EventExited, ExitCode: -1, Err: err})
+		return err
+	}
+	if jl.hasLimits() && proc.container != "" {
+		jl.log("container resource limits: %s", jl.limitsString())
+	} else if jl.hasLimits() && !hasResourceLimits {
+		jl.logWarn("resource limits are not supported on %s, ignored", runtime.GOOS)
+	} else if jl.hasLimits() {
+		if err := setResourceLimits(cmd.Process.Pid, jl.maxMemory, jl.maxProcs); err != nil {
+			// running without the limits is not what the operator asked for
+			killProcess(cmd.Process)
+			cmd.Wait()
+			closeCapture()
+			jl.setState(StateStopped)
+			err = fmt.Errorf("fail to set resource limits: %w", err)
+			jl.publish(Event{Type: EventExited, ExitCode: -1, Err: err})
+			return err
+		}
+		jl.log("resource limits: %s", jl.limitsString())
+	}
+	stopC, ctx := jl.stopC, jl.ctx
+	jl.proc = proc
+	jl.startedAt = time.Now()
+	jl.status.update(func(st *Status) {
+		st.JupyterPid = cmd.Process.Pid
+		st.StartTime = jl.startedAt
+		st.URL = ""
+	})
+	jl.logEvent(levelInfo, map[string]any{"pid": cmd.Process.Pid}, "jupyter lab started, pid %d", cmd.Process.Pid)
+	jl.watch(proc, cmd.Process.Pid, stopC)
+	go func() {
+		err := cmd.Wait()
+		if ctx.Err() != nil || jl.noActivity.Load() {
+			// stopped by the context or shut down by itself, not a crash
+			err = nil
+		}
+		closeCapture()
+		if jl.kernelLogs != nil {
+			jl.kernelLogs.closeAll()
+		}
+		jl.setState(StateStopped)
+		proc.exitCode = cmd.ProcessState.ExitCode()
+		if err != nil && jl.portInUse.Load() {
+			err = fmt.Errorf("port %d is already in use, use -port-auto to find a free port", jl.port)
+		} else if err != nil && jl.limitHit.Load() {
+			err = fmt.Errorf("exceeded the resource limits (%s): %w", jl.limitsString(), err)
+		}
+		proc.err = err
+		if err != nil {
+			jl.logEvent(levelError, map[string]any{"exit_code": proc.exitCode}, "fail to run: %v", err)
+		} else {
+			jl.logEvent(levelInfo, map[string]any{"exit_code": proc.exitCode}, "jupyter lab exit %d", proc.exitCode)
+		}
+		close(proc.done)
+		jl.publish(Event{Type: EventExited, Pid: cmd.Process.Pid, ExitCode: proc.exitCode, Err: err})
+		jl.exited(proc, err)
+	}()
+	return nil
+}
+
+// watch starts the health check, idle timeout and usage loops of proc of pid
+func (jl *JupyterLash) watch(proc *process, pid int, stopC <-chan struct{}) {
+	if jl.healthInterval > 0 {
+		go jl.healthLoop(proc, stopC)
+	}
+	if jl.idleTimeout > 0 {
+		go jl.idleLoop(proc.done, stopC)
+	}
+	if jl.usageInterval > 0 {
+		go jl.usageLoop(pid, proc.done, stopC)
+	}
+}
+
+// exited clears proc after the process exited, and restarts it if it was a crash
+func (jl *JupyterLash) exited(proc *process, err error) {
+	jl.Lock()
+	// not by Stop(), Restart() or the context
+	unexpected := jl.proc == proc && !jl.stopping && jl.ctx.Err() == nil
+	if jl.proc == proc {
+		jl.proc = nil
+		jl.exitCode = proc.exitCode
+	}
+	// restarting on the same busy port is pointless
+	restart := jl.restart && err != nil && !jl.portInUse.Load()
+	if unexpected && !restart {
+		jl.fail0(proc.exitCode)
+	}
+	jl.Unlock()
+	if unexpected && restart {
+		jl.restart0(proc.exitCode)
+	}
+}
+
+// fail0 closes failC once, the caller must hold the lock
+func (jl *JupyterLash) fail0(exitCode int) {
+	if jl.failC == nil {
+		jl.failC = make(chan struct{})
+	}
+	select {
+	case <-jl.failC:
+		return
+	default:
+	}
+	close(jl.failC)
+	jl.publish(Event{Type: EventFailed, ExitCode: exitCode})
+}
+
+const (
+	restartBackoffMin   = 1 * time.Second
+	restartBackoffMax   = 30 * time.Second
+	restartStablePeriod = 60 * time.Second
+)
+
+// restart0 relaunches jupyter after an unexpected exit with exponential backoff,
+// unless restart is disabled or Stop() has been called.
+func (jl *JupyterLash) restart0(exitCode int) {
+	jl.Lock()
+	if !jl.restart || jl.stopping || jl.proc != nil {
+		jl.Unlock()
+		return
+	}
+	if time.Since(jl.startedAt) > restartStablePeriod {
+		jl.failures = 0
+		jl.backoff = 0
+	}
+	jl.failures++
+	if jl.restartMax > 0 && jl.failures > jl.restartMax {
+		jl.logEvent(levelError, map[string]any{"exit_code": exitCode}, "jupyter lab exit %d, giving up after %d restarts", exitCode, jl.restartMax)
+		jl.fail0(exitCode)
+		jl.Unlock()
+		return
+	}
+	if jl.backoff == 0 {
+		jl.backoff = restartBackoffMin
+	} else {
+		jl.backoff = min(jl.backoff*2, restartBackoffMax)
+	}
+	delay, stopC := jl.backoff, jl.stopC
+	jl.publish(Event{Type: EventRestarting, ExitCode: exitCode})
+	jl.logEvent(levelDebug, map[string]any{"exit_code": exitCode, "attempt": jl.failures}, "jupyter lab exit %d, restarting in %s (attempt %d)...", exitCode, delay, jl.failures)
+	jl.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stopC:
+		return
+	}
+
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.stopping || jl.proc != nil {
+		return
+	}
+	jl.restarts++
+	if err := jl.start0(); err != nil {
+		jl.logError("%v", err)
+		jl.exitCode = -1
+		jl.fail0(-1)
+	}
+}
+
+// stop0 interrupts jupyter and waits for proc.done, that is closed by the
+// goroutine of cmd.Wait(), and kills it after the shutdown timeout.
+// The caller must hold the lock, the waiter does not take it before closing done.
+func (jl *JupyterLash) stop0() error {
+	proc := jl.proc
+	if proc == nil {
+		return nil
+	}
+	var err error
+	jl.interrupt(proc)
+	timer := time.NewTimer(jl.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-proc.done:
+	case <-jl.killC:
+		jl.logError("killing pid %d", proc.cmd.Process.Pid)
+		jl.kill(proc)
+		<-proc.done
+	case <-timer.C:
+		jl.logError("timeout after %s, killing pid %d", jl.shutdownTimeout, proc.cmd.Process.Pid)
+		if err = jl.kill(proc); err == nil {
+			err = fmt.Errorf("jupyter did not exit in %s, killed", jl.shutdownTimeout)
+		}
+		<-proc.done
+	}
+	jl.proc = nil
+	jl.exitCode = proc.exitCode
+	return err
+}
+
+// Token returns the access token of jupyter, empty if token authentication is disabled
+func (jl *JupyterLash) Token() string {
+	jl.RLock()
+	defer jl.RUnlock()
+	return jl.token
+}
+
+// ExitCode returns the exit code of the last jupyter process
+func (jl *JupyterLash) ExitCode() int {
+	jl.RLock()
+	defer jl.RUnlock()
+	return jl.exitCode
+}
+
+// IsRunning reports whether the jupyter process is alive
+func (jl *JupyterLash) IsRunning() bool {
+	jl.RLock()
+	proc := jl.proc
+	jl.RUnlock()
+	if proc == nil {
+		return false
+	}
+	select {
+	case <-proc.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait blocks until the current jupyter process exits and returns its exit code,
+// it returns the exit code of the last process immediately if not running.
+func (jl *JupyterLash) Wait() int {
+	jl.RLock()
+	proc, exitCode := jl.proc, jl.exitCode
+	jl.RUnlock()
+	if proc == nil {
+		return exitCode
+	}
+	<-proc.done
+	return proc.exitCode
+}
+
+// State returns the state of jupyter
+func (jl *JupyterLash) State() State {
+	return jl.status.get().State
+}
+
+// NormalizeBaseURL returns the base url with leading and trailing slashes.
+// It returns an error if the url has spaces or control characters.
+func NormalizeBaseURL(u string) (string, error) {
+	for _, r := range u {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return "", fmt.Errorf("invalid base url %q", u)
+		}
+	}
+	u = "/" + strings.Trim(u, "/")
+	if u != "/" {
+		u += "/"
+	}
+	return u, nil
+}
+
+// NormalizeBind returns the ip address with the brackets of an IPv6 address
+// removed, e.g. [::1] as in a url. It returns an error if it is not an ip address.
+func NormalizeBind(ip string) (string, error) {
+	if strings.HasPrefix(ip, "[") && strings.HasSuffix(ip, "]") {
+		ip = ip[1 : len(ip)-1]
+	}
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid bind address %q", ip)
+	}
+	return ip, nil
+}
+
+// serverArgs returns the jupyter command line with the settings
+func (jl *JupyterLash) serverArgs() []string {
+	return jl.serverArgsFor(jl.notebookDir, jl.bindIP)
+}
+
+// serverArgsFor returns the server args with the notebook dir and the ip,
+// that are different in a container.
+func (jl *JupyterLash) serverArgsFor(notebookDir string, ip string) []string {
+	tokenOpt := "--ServerApp.token="
+	if jl.ui == "lab" {
+		tokenOpt = "--LabApp.token="
+	}
+	tokenArg := tokenOpt + "''" // disable token
+	if jl.token != "" {
+		tokenArg = tokenOpt + jl.token
+	}
+	args := []string{jl.ui,
+		"-y",
+		"--no-browser",
+		"--notebook-dir", notebookDir,
+	}
+	if jl.sock != "" {
+		args = append(args, "--ServerApp.sock="+jl.sock)
+	} else {
+		args = append(args, "--ip="+ip, fmt.Sprintf("--port=%d", jl.port))
+	}
+	args = append(args,
+		"--ServerApp.base_url="+jl.baseURL,
+		"--ServerApp.allow_remote_access="+PyBool(jl.allowRemote),
+		tokenArg,
+	)
+	if jl.certFile != "" && jl.keyFile != "" {
+		args = append(args, "--certfile="+jl.certFile, "--keyfile="+jl.keyFile)
+	}
+	return append(args, jl.settingArgs()...)
+}
+
+// PyBool returns b as a python literal, e.g. for a value of the settings.
+func PyBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+func (jl *JupyterLash) settingArgs() []string {
+	keys := make([]string, 0, len(jl.settings))
+	for k := range jl.settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--%s=%s", k, jl.settings[k]))
+	}
+	return args
+}
+
+// ParseSetting parses key=value, and qualifies key with ServerApp
+// if it has no class prefix, e.g. "LabApp.collaborative".
+func ParseSetting(kv string) (string, string, error) {
+	k, v, ok := strings.Cut(kv, "=")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return "", "", fmt.Errorf("%q is not key=value", kv)
+	}
+	if !strings.Contains(k, ".") {
+		k = "ServerApp." + k
+	}
+	return k, v, nil
+}
+
+// managedArgs are the jupyter options that neo-jupyter sets by itself
+var managedArgs = []string{
+	"notebook-dir", "ServerApp.root_dir",
+	"ip", "ServerApp.ip",
+	"port", "ServerApp.port",
+	"sock", "ServerApp.sock",
+	"ServerApp.base_url",
+	"LabApp.token", "ServerApp.token", "IdentityProvider.token",
+	"certfile", "ServerApp.certfile",
+	"keyfile", "ServerApp.keyfile",
+}
+
+// managedArgName returns the option name if arg sets one of managedArgs
+func managedArgName(arg string) string {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	for _, m := range managedArgs {
+		if strings.EqualFold(name, m) {
+			return m
+		}
+	}
+	return ""
+}
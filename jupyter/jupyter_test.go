@@ -0,0 +1,473 @@
+package jupyter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// helperEnv selects the behavior of TestHelperProcess running as the fake python
+const helperEnv = "GO_WANT_HELPER_PROCESS"
+
+// fakeCommand runs TestHelperProcess of this test binary instead of the python command
+func fakeCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--", name}, args...)...)
+}
+
+// TestHelperProcess is not a test, it is the fake python started by fakeCommand.
+//
+//	serve  prints the server url and runs until it is signaled
+//	boot   runs until it is signaled without printing the server url
+//	crash  exits with 3 after 200ms
+//	wedge  ignores SIGTERM, prints the server url and runs until it is killed
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv(helperEnv)
+	if mode == "" {
+		return
+	}
+	switch mode {
+	case "serve", "wedge":
+		if mode == "wedge" {
+			signal.Ignore(syscall.SIGTERM)
+		}
+		fmt.Fprintln(os.Stderr, "[I ServerApp] Jupyter Server 2.10.0 is running at:")
+		fmt.Fprintln(os.Stderr, "[I ServerApp] http://127.0.0.1:8888"+DefaultBaseURL+"lab?token=...")
+		if tok := os.Getenv("HELPER_TOKEN"); tok != "" {
+			// as an older jupyter prints it
+			fmt.Fprintln(os.Stderr, "[I ServerApp]  or http://127.0.0.1:8888"+DefaultBaseURL+"lab?token="+tok)
+		}
+		time.Sleep(time.Minute)
+	case "boot":
+		time.Sleep(time.Minute)
+	case "crash":
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprintln(os.Stderr, "crashed")
+		os.Exit(3)
+	}
+	os.Exit(0)
+}
+
+// newFake returns a JupyterLash that runs TestHelperProcess in mode instead of python
+func newFake(t *testing.T, mode string, opts ...Option) *JupyterLash {
+	t.Helper()
+	opts = append([]Option{
+		WithPython("python3"),
+		WithNotebookDir(t.TempDir()),
+		WithEnv(helperEnv + "=" + mode),
+		WithShutdownTimeout(2 * time.Second),
+		WithLogger(NewStdLogger("text", io.Discard, io.Discard)),
+	}, opts...)
+	jl := New(opts...)
+	jl.execCommand = fakeCommand
+	t.Cleanup(func() { jl.Stop() })
+	return jl
+}
+
+// waitFor fails the test if cond is not true in 5s
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	jl := newFake(t, "serve")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !jl.IsRunning() {
+		t.Fatal("not running after Start")
+	}
+	waitFor(t, "the server url", func() bool { return jl.ServerURL() != "" })
+	if s := jl.State(); s != StateRunning {
+		t.Fatalf("state %s, want %s", s, StateRunning)
+	}
+	if err := jl.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if jl.IsRunning() {
+		t.Fatal("running after Stop")
+	}
+	if s := jl.State(); s != StateStopped {
+		t.Fatalf("state %s, want %s", s, StateStopped)
+	}
+	select {
+	case <-jl.Failed():
+		t.Fatal("failed after Stop")
+	default:
+	}
+}
+
+func TestRestart(t *testing.T) {
+	jl := newFake(t, "serve")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := jl.status.get().JupyterPid
+	if err := jl.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if !jl.IsRunning() {
+		t.Fatal("not running after Restart")
+	}
+	if pid2 := jl.status.get().JupyterPid; pid2 == pid {
+		t.Fatalf("pid %d is not changed by Restart", pid)
+	}
+	select {
+	case <-jl.Stopped():
+		t.Fatal("stopped by Restart")
+	default:
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	jl := newFake(t, "serve")
+	jl.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "/nonexistent/python", args...)
+	}
+	if err := jl.Start(); err == nil {
+		t.Fatal("no error starting a missing python")
+	}
+	if jl.IsRunning() {
+		t.Fatal("running after a failed Start")
+	}
+}
+
+func TestCrash(t *testing.T) {
+	jl := newFake(t, "crash")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-jl.Failed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not failed after the crash")
+	}
+	if code := jl.ExitCode(); code != 3 {
+		t.Fatalf("exit code %d, want 3", code)
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	jl := newFake(t, "crash", WithRestart(1))
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var restarting bool
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case evt := <-jl.Events():
+			switch evt.Type {
+			case EventRestarting:
+				restarting = true
+			case EventFailed:
+				if !restarting {
+					t.Fatal("failed without restarting")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("not failed after restartMax restarts")
+		}
+	}
+}
+
+func TestIsRunningWait(t *testing.T) {
+	jl := newFake(t, "crash")
+	if jl.IsRunning() {
+		t.Fatal("running before Start")
+	}
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !jl.IsRunning() {
+		t.Fatal("not running before Wait")
+	}
+	if code := jl.Wait(); code != 3 {
+		t.Fatalf("Wait returned %d, want 3", code)
+	}
+	if jl.IsRunning() {
+		t.Fatal("running after Wait")
+	}
+	// not running, the exit code of the last process
+	if code := jl.Wait(); code != 3 {
+		t.Fatalf("Wait returned %d after exit, want 3", code)
+	}
+}
+
+// TestStartStopRace starts and stops jupyter while the accessors are polled,
+// it is meant to be run with -race.
+func TestStartStopRace(t *testing.T) {
+	for _, mode := range []string{"serve", "wedge"} {
+		t.Run(mode, func(t *testing.T) {
+			jl := newFake(t, mode, WithShutdownTimeout(500*time.Millisecond))
+			done := make(chan struct{})
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					jl.IsRunning()
+					jl.State()
+					jl.ServerURL()
+					jl.ExitCode()
+					jl.Stopped()
+					jl.Failed()
+					time.Sleep(time.Millisecond)
+				}
+			}()
+			for i := 0; i < 3; i++ {
+				if err := jl.Start(); err != nil {
+					t.Fatal(err)
+				}
+				waitFor(t, "the server url", func() bool { return jl.ServerURL() != "" })
+				go jl.Wait()
+				jl.Stop()
+				if jl.IsRunning() {
+					t.Fatal("running after Stop")
+				}
+			}
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		bind      string
+		url       string
+		statusURL string
+	}{
+		{"127.0.0.1", "http://127.0.0.1:8888/base/", "http://127.0.0.1:8888/base/api/status"},
+		{"0.0.0.0", "http://0.0.0.0:8888/base/", "http://127.0.0.1:8888/base/api/status"},
+		{"::1", "http://[::1]:8888/base/", "http://[::1]:8888/base/api/status"},
+		{"[::1]", "http://[::1]:8888/base/", "http://[::1]:8888/base/api/status"},
+		{"::", "http://[::]:8888/base/", "http://[::1]:8888/base/api/status"},
+		{"[::]", "http://[::]:8888/base/", "http://[::1]:8888/base/api/status"},
+		{"fe80::1", "http://[fe80::1]:8888/base/", "http://[fe80::1]:8888/base/api/status"},
+	}
+	for _, tt := range tests {
+		jl := New(WithBind(tt.bind), WithPort(8888), WithBaseURL("/base"))
+		if u := jl.url(); u != tt.url {
+			t.Errorf("bind %s: url %s, want %s", tt.bind, u, tt.url)
+		}
+		if u := jl.apiURL("api/status"); u != tt.statusURL {
+			t.Errorf("bind %s: status url %s, want %s", tt.bind, u, tt.statusURL)
+		}
+	}
+}
+
+func TestHealthCheckWaitsForReady(t *testing.T) {
+	// nothing listens on the port, every check fails
+	jl := newFake(t, "boot", WithPort(freePort(t)), WithHealthCheck(20*time.Millisecond, 1), WithRestart(0))
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !jl.IsRunning() {
+		t.Fatal("killed by the health check before ready")
+	}
+}
+
+// freePort returns a port that nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestReadyLineMasksToken(t *testing.T) {
+	var buf syncBuffer
+	jl := newFake(t, "serve", WithToken("s3cr3t"), WithReadyLine(&buf))
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the ready line", func() bool { return buf.String() != "" })
+	var line ReadyLine
+	if err := json.Unmarshal([]byte(buf.String()), &line); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(line.URL, "s3cr3t") || !strings.Contains(line.URL, "token=...") {
+		t.Fatalf("ready url %s, want the token masked", line.URL)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestRestartFailure(t *testing.T) {
+	jl := newFake(t, "serve")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	jl.Lock()
+	jl.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "/nonexistent/python", args...)
+	}
+	jl.Unlock()
+	if err := jl.Restart(); err == nil {
+		t.Fatal("no error restarting a missing python")
+	}
+	select {
+	case <-jl.Failed():
+	default:
+		t.Fatal("not failed after a failed restart")
+	}
+	if code := jl.ExitCode(); code != -1 {
+		t.Fatalf("exit code %d, want -1", code)
+	}
+}
+
+func TestReloadFailure(t *testing.T) {
+	jl := newFake(t, "serve")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	jl.Lock()
+	jl.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "/nonexistent/python", args...)
+	}
+	jl.Unlock()
+	if err := jl.Reload(t.TempDir(), nil); err == nil {
+		t.Fatal("no error reloading with a missing python")
+	}
+	select {
+	case <-jl.Failed():
+	default:
+		t.Fatal("not failed after a failed reload")
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	jl := newFake(t, "serve", WithGeneratedConfig(false), WithToken("s3cr3t"))
+	var buf bytes.Buffer
+	if err := jl.DryRun(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Errorf("dry run printed the token:\n%s", buf.String())
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) > 0 {
+		t.Errorf("dry run created %s in the temp dir", entries[0].Name())
+	}
+}
+
+// TestReportStartStopRace starts and stops jupyter with the heartbeat, it is meant to be run with -race.
+func TestReportStartStopRace(t *testing.T) {
+	var count atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+	}))
+	defer srv.Close()
+	jl := newFake(t, "serve", WithReport(srv.URL, time.Hour))
+	for i := 0; i < 3; i++ {
+		if err := jl.Start(); err != nil {
+			t.Fatal(err)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			jl.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop hangs waiting for the report loop")
+		}
+	}
+	if count.Load() == 0 {
+		t.Fatal("no heartbeat posted")
+	}
+}
+
+func TestTokenNotLogged(t *testing.T) {
+	var out syncBuffer
+	jl := newFake(t, "serve", WithToken("s3cr3t"), WithEnv("HELPER_TOKEN=s3cr3t"), WithLogger(NewStdLogger("text", &out, &out)))
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the server url", func() bool { return jl.ServerURL() != "" })
+	jl.Stop()
+	if strings.Contains(out.String(), "s3cr3t") {
+		t.Fatalf("the token is logged:\n%s", out.String())
+	}
+	if tok := jl.Token(); tok != "s3cr3t" {
+		t.Fatalf("Token() %q", tok)
+	}
+}
+
+func TestDockerEnvByName(t *testing.T) {
+	t.Setenv(envPrefix+"HTTP", "http://127.0.0.1:5654")
+	jl := New(WithDocker("neo/jupyter"), WithNotebookDir(t.TempDir()),
+		WithEnvFile("API_SECRET=s3cr3t"), WithEnv("EXTRA=v1"),
+		WithLogger(NewStdLogger("text", io.Discard, io.Discard)))
+	jl.ctx = context.Background()
+	jl.execCommand = exec.CommandContext
+	cmd := jl.dockerCommand()
+	args := strings.Join(cmd.Args, " ")
+	for _, k := range []string{envPrefix + "HTTP", "API_SECRET", "EXTRA"} {
+		if !strings.Contains(args, "-e "+k+" ") {
+			t.Errorf("%s not forwarded: %s", k, args)
+		}
+	}
+	for _, v := range []string{"5654", "s3cr3t", "v1"} {
+		if strings.Contains(args, v) {
+			t.Errorf("value %q in docker args: %s", v, args)
+		}
+	}
+	if strings.Contains(args, "-e HOME") || strings.Contains(args, "-e PATH") {
+		t.Errorf("host variables forwarded: %s", args)
+	}
+	for k, v := range map[string]string{envPrefix + "HTTP": "http://127.0.0.1:5654", "API_SECRET": "s3cr3t", "EXTRA": "v1"} {
+		if got := getEnv(cmd.Env, k); got != v {
+			t.Errorf("docker env %s=%q, want %q", k, got, v)
+		}
+	}
+}
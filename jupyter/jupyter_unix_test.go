@@ -0,0 +1,26 @@
+//go:build !windows
+
+package jupyter
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestStartStopTerminates(t *testing.T) {
+	jl := newFake(t, "serve")
+	if err := jl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := jl.status.get().JupyterPid
+	if pid <= 0 {
+		t.Fatal("no pid after Start")
+	}
+	if err := jl.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Fatalf("pid %d after Stop: %v, want ESRCH", pid, err)
+	}
+}
@@ -0,0 +1,109 @@
+package jupyter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KernelName is the name of the machbase sql kernel installed by WithInstallKernel
+const KernelName = "machbase-sql"
+
+// kernelModule is the python module that implements the machbase sql kernel
+const kernelModule = "machbase_kernel"
+
+// installKernel registers the machbase sql kernel to the user's jupyter data dir
+// with jupyter kernelspec install, unless it is already registered.
+func (jl *JupyterLash) installKernel() error {
+	specs, err := jl.kernelSpecs()
+	if err != nil {
+		return err
+	}
+	if _, ok := specs[KernelName]; ok {
+		jl.log("kernel %s is already installed", KernelName)
+		return nil
+	}
+	// the kernel would be listed but fail to start every time
+	if err := jl.checkImport(kernelModule); err != nil {
+		jl.logWarn("kernel %s is not installed, python module %s is not available: %v", KernelName, kernelModule, err)
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "neo-jupyter-kernel-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	specDir := filepath.Join(dir, KernelName)
+	if err := os.Mkdir(specDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(jl.kernelSpec(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(specDir, "kernel.json"), b, 0644); err != nil {
+		return err
+	}
+	if jl.account != nil {
+		if err := jl.account.Chown(dir); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	cmd := jl.pythonCommand(ctx, "-m", "jupyter", "kernelspec", "install", "--user", "--name", KernelName, specDir)
+	if err := jl.runLogged(cmd); err != nil {
+		return fmt.Errorf("jupyter kernelspec install: %w", err)
+	}
+	jl.log("kernel %s is installed", KernelName)
+	return nil
+}
+
+// checkImport returns an error if python fails to import module
+func (jl *JupyterLash) checkImport(module string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, "-c", "import "+module).CombinedOutput()
+	if err != nil {
+		if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); lines[len(lines)-1] != "" {
+			return fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+		}
+		return err
+	}
+	return nil
+}
+
+// kernelSpecs returns the installed kernelspecs by name
+func (jl *JupyterLash) kernelSpecs() (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, "-m", "jupyter", "kernelspec", "list", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("jupyter kernelspec list: %w", err)
+	}
+	list := struct {
+		Kernelspecs map[string]json.RawMessage `json:"kernelspecs"`
+	}{}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("jupyter kernelspec list: %w", err)
+	}
+	return list.Kernelspecs, nil
+}
+
+// kernelSpec returns the kernel.json of the machbase sql kernel. It has no env,
+// the kernel inherits the MACHBASE_NEO_ variables from jupyter, so that the
+// passwords and tokens among them are not written to the kernelspec.
+func (jl *JupyterLash) kernelSpec() map[string]any {
+	python := jl.pythonBin
+	if python == "" {
+		python = "python"
+	}
+	return map[string]any{
+		"argv":         []string{python, "-m", kernelModule, "-f", "{connection_file}"},
+		"display_name": "Machbase SQL",
+		"language":     "sql",
+	}
+}
@@ -0,0 +1,107 @@
+package jupyter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// kernelIDRegexp matches the kernel id of the jupyter log, e.g. "Kernel started: <id>, name: python3"
+var kernelIDRegexp = regexp.MustCompile(`\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+
+// kernelGoneRegexp matches the log of a kernel that does not write any more
+var kernelGoneRegexp = regexp.MustCompile(`Kernel shutdown: |Kernel deleted: `)
+
+// maxKernelLogFiles bounds the open kernel log files, the least recently written is closed first
+const maxKernelLogFiles = 16
+
+// kernelLogs writes the jupyter log lines of each kernel to kernel-<id>.log in dir
+type kernelLogs struct {
+	sync.Mutex
+	dir   string
+	files map[string]*kernelLogFile
+}
+
+type kernelLogFile struct {
+	f    *os.File
+	used time.Time
+}
+
+// kernelID returns the kernel id of the line, empty if it has none
+func kernelID(line string) string {
+	return kernelIDRegexp.FindString(line)
+}
+
+// write appends line to the log of the kernel id,
+// and closes it if the line tells the kernel is gone.
+func (kl *kernelLogs) write(id string, line string) error {
+	kl.Lock()
+	defer kl.Unlock()
+	lf, ok := kl.files[id]
+	if !ok {
+		if len(kl.files) >= maxKernelLogFiles {
+			kl.closeOldest()
+		}
+		if err := os.MkdirAll(kl.dir, 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(kl.dir, "kernel-"+id+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		if kl.files == nil {
+			kl.files = map[string]*kernelLogFile{}
+		}
+		lf = &kernelLogFile{f: f}
+		kl.files[id] = lf
+	}
+	lf.used = time.Now()
+	_, err := fmt.Fprintf(lf.f, "%s %s\n", lf.used.Format(time.RFC3339), line)
+	if kernelGoneRegexp.MatchString(line) {
+		lf.f.Close()
+		delete(kl.files, id)
+	}
+	return err
+}
+
+// closeOldest closes the least recently written file, it is reopened on the next write
+func (kl *kernelLogs) closeOldest() {
+	var oldest string
+	for id, lf := range kl.files {
+		if oldest == "" || lf.used.Before(kl.files[oldest].used) {
+			oldest = id
+		}
+	}
+	if oldest != "" {
+		kl.files[oldest].f.Close()
+		delete(kl.files, oldest)
+	}
+}
+
+// closeAll closes the files, the kernels are gone with jupyter
+func (kl *kernelLogs) closeAll() {
+	kl.Lock()
+	defer kl.Unlock()
+	for id, lf := range kl.files {
+		lf.f.Close()
+		delete(kl.files, id)
+	}
+}
+
+// kernelFields returns the log fields of the kernel of line, and writes it to the
+// kernel log if enabled. It is nil if line has no kernel id.
+func (jl *JupyterLash) kernelFields(line string) map[string]any {
+	id := kernelID(line)
+	if id == "" {
+		return nil
+	}
+	if jl.kernelLogs != nil {
+		if err := jl.kernelLogs.write(id, line); err != nil && !jl.kernelLogFailed.Swap(true) {
+			jl.logError("fail to write kernel log: %v", err)
+		}
+	}
+	return map[string]any{"kernel": id}
+}
@@ -0,0 +1,39 @@
+package jupyter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// limitHitRegexp matches the errors of an exceeded resource limit in jupyter's output,
+// e.g. "MemoryError", "OSError: [Errno 12] Cannot allocate memory" or
+// "BlockingIOError: [Errno 11] Resource temporarily unavailable" of fork.
+var limitHitRegexp = regexp.MustCompile(`MemoryError|(?i)cannot allocate memory|errno 1[12]\b|resource temporarily unavailable`)
+
+// scanLimitHit logs once per process that jupyter or a kernel hit the resource limits,
+// so that it is not mistaken for a normal crash.
+func (jl *JupyterLash) scanLimitHit(line string) {
+	if !jl.hasLimits() || !limitHitRegexp.MatchString(line) {
+		return
+	}
+	if !jl.limitHit.Swap(true) {
+		jl.logWarn("jupyter hit the resource limits (%s): %s", jl.limitsString(), line)
+	}
+}
+
+func (jl *JupyterLash) hasLimits() bool {
+	return jl.maxMemory > 0 || jl.maxProcs > 0
+}
+
+// limitsString returns the limits for the logs, e.g. "max-memory 2147483648, max-procs 256"
+func (jl *JupyterLash) limitsString() string {
+	var s []string
+	if jl.maxMemory > 0 {
+		s = append(s, fmt.Sprintf("max-memory %d", jl.maxMemory))
+	}
+	if jl.maxProcs > 0 {
+		s = append(s, fmt.Sprintf("max-procs %d", jl.maxProcs))
+	}
+	return strings.Join(s, ", ")
+}
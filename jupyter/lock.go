@@ -0,0 +1,55 @@
+package jupyter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrLocked = errors.New("another instance is starting/running")
+
+// InstanceLock is an advisory lock of a file held while the process runs,
+// the OS releases it when the process exits, even if it is killed.
+type InstanceLock struct {
+	f *os.File
+}
+
+// LockInstance locks path without waiting, it returns an error wrapping
+// ErrLocked if another process holds it. The lock file is left in place,
+// removing it would let two processes lock different files of the same path.
+func LockInstance(path string) (*InstanceLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		b := make([]byte, 32)
+		n, _ := f.Read(b)
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(b[:n]))); err == nil {
+				return nil, fmt.Errorf("%w (pid %d), lock %s", ErrLocked, pid, path)
+			}
+			return nil, fmt.Errorf("%w, lock %s", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("fail to lock %s: %w", path, err)
+	}
+	// the pid is informational, the lock is what matters
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return &InstanceLock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *InstanceLock) Unlock() {
+	if l != nil && l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package jupyter
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes the flock of f without waiting
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package jupyter
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile locks the first byte of f with LockFileEx without waiting
+func lockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		if err == errorLockViolation {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
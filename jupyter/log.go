@@ -0,0 +1,258 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+// levelRank orders the levels, a message below the level of the logger is dropped
+func levelRank(level string) int {
+	switch level {
+	case levelDebug:
+		return 0
+	case levelInfo:
+		return 1
+	case levelWarn:
+		return 2
+	case levelError:
+		return 3
+	}
+	return -1
+}
+
+// StdLogger writes debug and info messages to out and warn and error messages to errOut,
+// as plain text lines or as one JSON object per line.
+type StdLogger struct {
+	sync.Mutex
+	json   bool
+	rank   int // of the minimum level, info by default
+	utc    bool
+	now    func() time.Time
+	out    io.Writer
+	errOut io.Writer
+	outEnc *json.Encoder
+	errEnc *json.Encoder
+	buf    *LogBuffer
+}
+
+// NewStdLogger returns a logger, format is "text" or "json".
+func NewStdLogger(format string, out io.Writer, errOut io.Writer) *StdLogger {
+	l := &StdLogger{out: out, errOut: errOut, rank: levelRank(levelInfo), now: time.Now}
+	if format == "json" {
+		l.json = true
+		l.outEnc = json.NewEncoder(out)
+		l.errEnc = json.NewEncoder(errOut)
+	}
+	return l
+}
+
+// SetLevel sets the minimum level: debug, info, warn or error.
+// It is not safe to call while logging.
+func (l *StdLogger) SetLevel(level string) error {
+	rank := levelRank(level)
+	if rank < 0 {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	l.rank = rank
+	return nil
+}
+
+// SetUTC makes the timestamps in UTC instead of the local time.
+func (l *StdLogger) SetUTC(utc bool) {
+	l.utc = utc
+}
+
+// SetBuffer keeps the messages in b too, the ones of info and above
+// even if they are below the level. It is not safe to call while logging.
+func (l *StdLogger) SetBuffer(b *LogBuffer) {
+	l.buf = b
+}
+
+// SetClock replaces the source of the timestamps, e.g. a fixed time in tests.
+func (l *StdLogger) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+func (l *StdLogger) Debugf(f string, args ...any) {
+	l.write(levelDebug, nil, f, args...)
+}
+
+func (l *StdLogger) Infof(f string, args ...any) {
+	l.write(levelInfo, nil, f, args...)
+}
+
+func (l *StdLogger) Warnf(f string, args ...any) {
+	l.write(levelWarn, nil, f, args...)
+}
+
+func (l *StdLogger) Errorf(f string, args ...any) {
+	l.write(levelError, nil, f, args...)
+}
+
+// enabled reports whether the messages of level are written
+func (l *StdLogger) enabled(level string) bool {
+	return levelRank(level) >= l.rank
+}
+
+func (l *StdLogger) write(level string, fields map[string]any, f string, args ...any) {
+	enabled := l.enabled(level)
+	if !enabled && (l.buf == nil || level == levelDebug) {
+		return
+	}
+	msg := f
+	if len(args) > 0 {
+		msg = fmt.Sprintf(f, args...)
+	}
+	now := l.now()
+	if l.utc {
+		now = now.UTC()
+	}
+	if l.buf != nil {
+		l.buf.add(LogLine{Time: now, Level: level, Msg: msg})
+	}
+	if !enabled {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	toErr := level == levelError || level == levelWarn
+	if !l.json {
+		ts := now.Format(time.RFC3339)
+		switch {
+		case level == levelWarn:
+			fmt.Fprintln(l.errOut, ts, "WARNING: "+msg)
+		case toErr:
+			fmt.Fprintln(l.errOut, ts, msg)
+		default:
+			fmt.Fprintln(l.out, ts, msg)
+		}
+		return
+	}
+	rec := make(map[string]any, len(fields)+3)
+	for k, v := range fields {
+		rec[k] = v
+	}
+	rec["time"] = now.Format(time.RFC3339Nano)
+	rec["level"] = level
+	rec["msg"] = msg
+	if toErr {
+		l.errEnc.Encode(rec)
+	} else {
+		l.outEnc.Encode(rec)
+	}
+}
+
+// Logger is the logger of JupyterLash, *StdLogger is the default.
+// Debug and warn messages use Debugf and Warnf if the logger has them,
+// otherwise debug messages are dropped and warn messages go to Errorf.
+type Logger interface {
+	Infof(f string, args ...any)
+	Errorf(f string, args ...any)
+}
+
+var defaultLogger Logger = NewStdLogger("text", os.Stdout, os.Stderr)
+
+// writeLog writes the message of level to lg, fields are kept only by *StdLogger
+func writeLog(lg Logger, level string, fields map[string]any, f string, args ...any) {
+	if sl, ok := lg.(*StdLogger); ok {
+		sl.write(level, fields, f, args...)
+		return
+	}
+	switch level {
+	case levelDebug:
+		if d, ok := lg.(interface{ Debugf(string, ...any) }); ok {
+			d.Debugf(f, args...)
+		}
+	case levelWarn:
+		if w, ok := lg.(interface{ Warnf(string, ...any) }); ok {
+			w.Warnf(f, args...)
+		} else {
+			lg.Errorf("WARNING: "+f, args...)
+		}
+	case levelError:
+		lg.Errorf(f, args...)
+	default:
+		lg.Infof(f, args...)
+	}
+}
+
+func (jl *JupyterLash) logger() Logger {
+	if jl.lg != nil {
+		return jl.lg
+	}
+	return defaultLogger
+}
+
+// debugEnabled reports whether the debug messages are written, to skip preparing them
+func (jl *JupyterLash) debugEnabled() bool {
+	switch lg := jl.logger().(type) {
+	case *StdLogger:
+		return lg.enabled(levelDebug)
+	case interface{ Debugf(string, ...any) }:
+		return true
+	}
+	return false
+}
+
+func (jl *JupyterLash) log(f string, args ...any) {
+	writeLog(jl.logger(), levelInfo, nil, f, args...)
+}
+
+func (jl *JupyterLash) logDebug(f string, args ...any) {
+	writeLog(jl.logger(), levelDebug, nil, f, args...)
+}
+
+func (jl *JupyterLash) logWarn(f string, args ...any) {
+	writeLog(jl.logger(), levelWarn, nil, f, args...)
+}
+
+func (jl *JupyterLash) logError(f string, args ...any) {
+	writeLog(jl.logger(), levelError, nil, f, args...)
+}
+
+func (jl *JupyterLash) jupyterLogger() Logger {
+	if jl.jupyterLg != nil {
+		return jl.jupyterLg
+	}
+	return jl.logger()
+}
+
+// logJupyter logs a line of jupyter's stdout
+func (jl *JupyterLash) logJupyter(f string, args ...any) {
+	line := fmt.Sprintf(f, args...)
+	jl.scanServerURL(line)
+	jl.scanLimitHit(line)
+	jl.scanNoActivity(line)
+	fields := jl.kernelFields(line)
+	if jl.discardStdout {
+		return
+	}
+	// an older jupyter prints the token in the url
+	writeLog(jl.jupyterLogger(), levelInfo, fields, "[jupyter] %s", jl.maskToken(line))
+}
+
+// logJupyterError logs a line of jupyter's stderr
+func (jl *JupyterLash) logJupyterError(f string, args ...any) {
+	line := fmt.Sprintf(f, args...)
+	jl.scanServerURL(line)
+	jl.scanPortInUse(line)
+	jl.scanLimitHit(line)
+	jl.scanNoActivity(line)
+	writeLog(jl.jupyterLogger(), levelError, jl.kernelFields(line), "[jupyter] %s", jl.maskToken(line))
+}
+
+// logEvent logs the message with event fields, e.g. pid and exit_code
+func (jl *JupyterLash) logEvent(level string, fields map[string]any, f string, args ...any) {
+	writeLog(jl.logger(), level, fields, f, args...)
+}
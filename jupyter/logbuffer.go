@@ -0,0 +1,58 @@
+package jupyter
+
+import (
+	"sync"
+	"time"
+)
+
+// statusLogLines is the number of the last log lines in the status file
+const statusLogLines = 20
+
+// LogLine is a log message kept by LogBuffer
+type LogLine struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+}
+
+// LogBuffer keeps the last log lines in memory, it is safe for concurrent use.
+// Set it to the loggers with SetBuffer.
+type LogBuffer struct {
+	sync.Mutex
+	lines []LogLine
+	next  int
+	full  bool
+}
+
+// NewLogBuffer returns a LogBuffer of the last size lines
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{lines: make([]LogLine, max(size, 1))}
+}
+
+func (b *LogBuffer) add(line LogLine) {
+	b.Lock()
+	defer b.Unlock()
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Tail returns the last n lines from the oldest, all of them if n <= 0
+func (b *LogBuffer) Tail(n int) []LogLine {
+	b.Lock()
+	defer b.Unlock()
+	count := b.next
+	if b.full {
+		count = len(b.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	ret := make([]LogLine, n)
+	for i := range ret {
+		ret[i] = b.lines[(b.next-n+i+len(b.lines))%len(b.lines)]
+	}
+	return ret
+}
@@ -0,0 +1,81 @@
+package jupyter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startMetrics serves /metrics in the prometheus text format on metricsAddr,
+// the caller must hold the lock.
+func (jl *JupyterLash) startMetrics() error {
+	if jl.metricsAddr == "" || jl.metricsSrv != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", jl.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("fail to listen metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", jl.serveMetrics)
+	jl.metricsSrv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go jl.metricsSrv.Serve(ln)
+	jl.log("metrics: http://%s/metrics", ln.Addr())
+	return nil
+}
+
+func (jl *JupyterLash) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	jl.RLock()
+	restarts, exitCode := jl.restarts, jl.exitCode
+	var uptime float64
+	if jl.proc != nil {
+		uptime = time.Since(jl.startedAt).Seconds()
+	}
+	jl.RUnlock()
+	state := jl.State()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP neo_jupyter_restarts_total Number of jupyter restarts.")
+	fmt.Fprintln(w, "# TYPE neo_jupyter_restarts_total counter")
+	fmt.Fprintf(w, "neo_jupyter_restarts_total %d\n", restarts)
+	fmt.Fprintln(w, "# HELP neo_jupyter_uptime_seconds Seconds since the current jupyter process started.")
+	fmt.Fprintln(w, "# TYPE neo_jupyter_uptime_seconds gauge")
+	fmt.Fprintf(w, "neo_jupyter_uptime_seconds %g\n", uptime)
+	fmt.Fprintln(w, "# HELP neo_jupyter_state State of jupyter, 1 for the current state.")
+	fmt.Fprintln(w, "# TYPE neo_jupyter_state gauge")
+	for _, s := range []State{StateStopped, StateStarting, StateRunning, StateUnreachable} {
+		v := 0
+		if s == state {
+			v = 1
+		}
+		fmt.Fprintf(w, "neo_jupyter_state{state=%q} %d\n", s, v)
+	}
+	fmt.Fprintln(w, "# HELP neo_jupyter_last_exit_code Exit code of the last jupyter process.")
+	fmt.Fprintln(w, "# TYPE neo_jupyter_last_exit_code gauge")
+	fmt.Fprintf(w, "neo_jupyter_last_exit_code %d\n", exitCode)
+	if u, ok := jl.Usage(); ok {
+		fmt.Fprintln(w, "# HELP neo_jupyter_memory_rss_bytes Resident memory of jupyter and its kernels.")
+		fmt.Fprintln(w, "# TYPE neo_jupyter_memory_rss_bytes gauge")
+		fmt.Fprintf(w, "neo_jupyter_memory_rss_bytes %d\n", u.RSS)
+		fmt.Fprintln(w, "# HELP neo_jupyter_cpu_seconds_total CPU time of jupyter and its running kernels.")
+		fmt.Fprintln(w, "# TYPE neo_jupyter_cpu_seconds_total counter")
+		fmt.Fprintf(w, "neo_jupyter_cpu_seconds_total %g\n", u.CPU.Seconds())
+		fmt.Fprintln(w, "# HELP neo_jupyter_processes Number of processes of jupyter and its kernels.")
+		fmt.Fprintln(w, "# TYPE neo_jupyter_processes gauge")
+		fmt.Fprintf(w, "neo_jupyter_processes %d\n", u.Processes)
+	}
+}
+
+// shutdownServer gracefully shuts down srv, the caller must not hold the lock
+func shutdownServer(srv *http.Server) {
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package jupyter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultPipIndexHost = "pypi.org:443"
+
+// offlineEnv makes pip of jupyter, its kernels and terminals never reach an index
+var offlineEnv = []string{"PIP_NO_INDEX=1", "PIP_DISABLE_PIP_VERSION_CHECK=1"}
+
+// missingRequirementsScript prints the requirements of the file in argv[1]
+// that have no installed distribution, it does not check the versions.
+const missingRequirementsScript = `import re, sys
+from importlib import metadata
+for line in open(sys.argv[1]):
+    line = line.split("#", 1)[0].strip()
+    if not line or line.startswith("-"):
+        continue
+    name = re.split(r"[\s;<>=!~\[@]", line, maxsplit=1)[0]
+    try:
+        metadata.distribution(name)
+    except metadata.PackageNotFoundError:
+        print(line)
+`
+
+// missingRequirements returns the requirements of path that are not installed,
+// without pip, so that nothing reaches the network.
+func (jl *JupyterLash) missingRequirements(path string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, "-c", missingRequirementsScript, path).Output()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			missing = append(missing, line)
+		}
+	}
+	return missing, nil
+}
+
+// checkPipIndex fails fast if the pip index can not be reached, instead of
+// letting pip retry against it. It is skipped if a proxy is configured.
+func (jl *JupyterLash) checkPipIndex() error {
+	for _, k := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if os.Getenv(k) != "" {
+			return nil
+		}
+	}
+	addr := defaultPipIndexHost
+	if jl.pipIndex != "" {
+		u, err := url.Parse(jl.pipIndex)
+		if err != nil || u.Host == "" {
+			// e.g. a local directory, pip reports it
+			return nil
+		}
+		addr = u.Host
+		if u.Port() == "" {
+			port := "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("pip index %s is not reachable, use offline mode to run with the installed packages: %w", addr, err)
+	}
+	conn.Close()
+	return nil
+}
@@ -0,0 +1,350 @@
+package jupyter
+
+import (
+	"io"
+	"syscall"
+	"time"
+)
+
+type Option func(jl *JupyterLash)
+
+// WithPython sets the python interpreter, it is discovered if empty.
+func WithPython(bin string) Option {
+	return func(jl *JupyterLash) { jl.pythonBin = bin }
+}
+
+// WithJupyter sets the jupyter executable, it is discovered if empty.
+func WithJupyter(bin string) Option {
+	return func(jl *JupyterLash) { jl.jupyterBin = bin }
+}
+
+func WithNotebookDir(dir string) Option {
+	return func(jl *JupyterLash) { jl.notebookDir = dir }
+}
+
+func WithPort(port int) Option {
+	return func(jl *JupyterLash) { jl.port = port }
+}
+
+// WithPortAuto makes Start() use the first free port of count ports from the port.
+func WithPortAuto(count int) Option {
+	return func(jl *JupyterLash) {
+		jl.portAuto = true
+		jl.portRange = count
+	}
+}
+
+// WithUI sets the jupyter subcommand: lab, notebook or server.
+func WithUI(ui string) Option {
+	return func(jl *JupyterLash) {
+		if _, ok := uis[ui]; ok {
+			jl.ui = ui
+		}
+	}
+}
+
+// WithBaseURL sets the base url of jupyter, normalized by NormalizeBaseURL.
+func WithBaseURL(u string) Option {
+	return func(jl *JupyterLash) {
+		if n, err := NormalizeBaseURL(u); err == nil {
+			jl.baseURL = n
+		}
+	}
+}
+
+// WithPortFile sets the file to write the selected port.
+func WithPortFile(path string) Option {
+	return func(jl *JupyterLash) { jl.portFile = path }
+}
+
+// WithBind sets the listen ip address, an IPv6 address may be bracketed.
+func WithBind(ip string) Option {
+	return func(jl *JupyterLash) {
+		if norm, err := NormalizeBind(ip); err == nil {
+			ip = norm
+		}
+		jl.bindIP = ip
+	}
+}
+
+// WithAllowRemote sets ServerApp.allow_remote_access, default is true
+// so that jupyter accepts the requests proxied by machbase-neo.
+func WithAllowRemote(allow bool) Option {
+	return func(jl *JupyterLash) { jl.allowRemote = allow }
+}
+
+// WithToken sets the access token, token authentication is disabled if empty.
+func WithToken(token string) Option {
+	return func(jl *JupyterLash) { jl.token = token }
+}
+
+func WithTLS(certFile string, keyFile string) Option {
+	return func(jl *JupyterLash) {
+		jl.certFile = certFile
+		jl.keyFile = keyFile
+	}
+}
+
+// WithRestart restarts jupyter when it crashes, giving up after max
+// consecutive failures (0: unlimited).
+func WithRestart(max int) Option {
+	return func(jl *JupyterLash) {
+		jl.restart = true
+		jl.restartMax = max
+	}
+}
+
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(jl *JupyterLash) { jl.shutdownTimeout = timeout }
+}
+
+// WithStopSignal sets the signal to stop jupyter gracefully, default is SIGTERM.
+// It is killed if it does not exit in the shutdown timeout. It is ignored on windows.
+func WithStopSignal(sig syscall.Signal) Option {
+	return func(jl *JupyterLash) { jl.stopSignal = sig }
+}
+
+// WithDiscoveryTimeout sets the timeout to find python and jupyter, default is 10s.
+func WithDiscoveryTimeout(timeout time.Duration) Option {
+	return func(jl *JupyterLash) { jl.discoveryTimeout = timeout }
+}
+
+// WithStartupTimeout makes Start() wait until jupyter is ready up to the timeout.
+func WithStartupTimeout(timeout time.Duration) Option {
+	return func(jl *JupyterLash) { jl.startupTimeout = timeout }
+}
+
+// WithSocket makes jupyter listen on the unix socket path instead of the port,
+// the dir is created and a stale socket file is removed before the start.
+func WithSocket(path string) Option {
+	return func(jl *JupyterLash) { jl.sock = path }
+}
+
+// WithDocker runs jupyter of the image with docker instead of the local python,
+// the notebook dir is mounted at /home/jovyan/work of the container.
+func WithDocker(image string) Option {
+	return func(jl *JupyterLash) { jl.dockerImage = image }
+}
+
+// WithExternalURL monitors the jupyter running at u with the health check
+// instead of launching one, Stop() just stops monitoring it.
+func WithExternalURL(u string) Option {
+	return func(jl *JupyterLash) { jl.externalURL = u }
+}
+
+// WithResourceLimits caps the address space in bytes and the number of processes
+// of jupyter and its kernels, 0 is unlimited. It is linux only, and ignored elsewhere.
+func WithResourceLimits(maxMemory int64, maxProcs int) Option {
+	return func(jl *JupyterLash) {
+		jl.maxMemory = maxMemory
+		jl.maxProcs = maxProcs
+	}
+}
+
+// WithIdleTimeout stops jupyter when it has no kernels and no sessions for the timeout.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(jl *JupyterLash) { jl.idleTimeout = timeout }
+}
+
+// WithDrain makes Restart wait up to timeout for the kernels to be idle before stopping jupyter.
+func WithDrain(timeout time.Duration) Option {
+	return func(jl *JupyterLash) { jl.drainTimeout = timeout }
+}
+
+// WithUsageInterval logs the memory and cpu usage of jupyter and its kernels
+// every interval, and exposes the last sample in the metrics. 0 disables it.
+func WithUsageInterval(interval time.Duration) Option {
+	return func(jl *JupyterLash) { jl.usageInterval = interval }
+}
+
+// WithMetrics serves prometheus metrics at http://addr/metrics while running.
+func WithMetrics(addr string) Option {
+	return func(jl *JupyterLash) { jl.metricsAddr = addr }
+}
+
+// WithReport posts a Heartbeat to url every interval while running.
+func WithReport(url string, interval time.Duration) Option {
+	return func(jl *JupyterLash) {
+		jl.reportURL = url
+		jl.reportInterval = interval
+	}
+}
+
+// WithAdmin serves the admin api at http://addr, protected by the bearer token.
+func WithAdmin(addr string, token string) Option {
+	return func(jl *JupyterLash) {
+		jl.adminAddr = addr
+		jl.adminToken = token
+	}
+}
+
+// WithHealthCheck polls api/status on every interval,
+// and kills jupyter after retries consecutive failures if restart is enabled.
+func WithHealthCheck(interval time.Duration, retries int) Option {
+	return func(jl *JupyterLash) {
+		jl.healthInterval = interval
+		jl.healthRetries = retries
+	}
+}
+
+// WithVenv runs jupyter in the virtualenv of the root directory.
+func WithVenv(dir string) Option {
+	return func(jl *JupyterLash) { jl.venv = dir }
+}
+
+// WithConda runs jupyter in the conda environment via "conda run".
+func WithConda(env string) Option {
+	return func(jl *JupyterLash) { jl.condaEnv = env }
+}
+
+// WithInstall makes Preflight() install jupyterlab with pip if it is missing,
+// pipIndex is the optional index url.
+func WithInstall(pipIndex string, timeout time.Duration) Option {
+	return func(jl *JupyterLash) {
+		jl.install = true
+		jl.pipIndex = pipIndex
+		jl.installTimeout = timeout
+	}
+}
+
+// WithCollaboration enables the real-time collaboration of jupyterlab in Preflight(),
+// installing the jupyter-collaboration extension if install is enabled.
+func WithCollaboration() Option {
+	return func(jl *JupyterLash) { jl.collaborative = true }
+}
+
+// WithLabExtensions enables and disables the lab extensions in Preflight().
+// An extension to enable can be name=pkg, pkg is installed with pip
+// if the extension is missing and install is enabled, default is name.
+func WithLabExtensions(enable []string, disable []string) Option {
+	return func(jl *JupyterLash) {
+		jl.enableExts = enable
+		jl.disableExts = disable
+	}
+}
+
+// WithOffline disables all the installs of Preflight(), that fails listing
+// the missing packages instead, and sets PIP_NO_INDEX for jupyter and its kernels.
+func WithOffline() Option {
+	return func(jl *JupyterLash) { jl.offline = true }
+}
+
+// WithRequirements sets the requirements file installed by Preflight()
+// if install is enabled, instead of requirements.txt in the notebook dir.
+func WithRequirements(path string) Option {
+	return func(jl *JupyterLash) { jl.requirements = path }
+}
+
+// WithInstallKernel registers the machbase sql kernel in Preflight() if it is not installed.
+func WithInstallKernel() Option {
+	return func(jl *JupyterLash) { jl.kernel = true }
+}
+
+// WithGeneratedConfig passes the settings to jupyter in a generated
+// jupyter_server_config.json instead of the command line,
+// the config is removed by Stop() unless keep is true.
+func WithGeneratedConfig(keep bool) Option {
+	return func(jl *JupyterLash) {
+		jl.genConfig = true
+		jl.keepConfig = keep
+	}
+}
+
+// WithSettings sets jupyter settings, see ParseSetting().
+func WithSettings(settings map[string]string) Option {
+	return func(jl *JupyterLash) {
+		for k, v := range settings {
+			jl.settings[k] = v
+		}
+	}
+}
+
+// WithJupyterDirs sets JUPYTER_DATA_DIR and JUPYTER_RUNTIME_DIR of jupyter,
+// an empty one is inherited.
+func WithJupyterDirs(dataDir string, runtimeDir string) Option {
+	return func(jl *JupyterLash) {
+		jl.dataDir = dataDir
+		jl.runtimeDir = runtimeDir
+	}
+}
+
+// WithReuse reuses the jupyter of the status file if it still serves the same
+// port, base url and token after its launcher is gone, instead of launching one.
+// The launched jupyter has no parent death signal then, so that it survives.
+func WithReuse() Option {
+	return func(jl *JupyterLash) { jl.reuse = true }
+}
+
+// WithSkipChecks skips the notebook dir write check.
+func WithSkipChecks() Option {
+	return func(jl *JupyterLash) { jl.skipChecks = true }
+}
+
+// WithAccount runs python and jupyter as the account, it requires root.
+func WithAccount(a *Account) Option {
+	return func(jl *JupyterLash) { jl.account = a }
+}
+
+// WithPythonPath prepends paths to PYTHONPATH of jupyter.
+func WithPythonPath(paths ...string) Option {
+	return func(jl *JupyterLash) { jl.pythonPath = append(jl.pythonPath, paths...) }
+}
+
+// WithEnvFile adds the KEY=VALUE entries of a .env file parsed by ParseEnvFile()
+// to the environment of jupyter before WithEnv(), their values are never logged.
+func WithEnvFile(env ...string) Option {
+	return func(jl *JupyterLash) { jl.fileEnv = env }
+}
+
+// WithEnv adds KEY=VALUE pairs to the environment of jupyter,
+// overriding the inherited ones.
+func WithEnv(env ...string) Option {
+	return func(jl *JupyterLash) { jl.env = append(jl.env, env...) }
+}
+
+// WithExtraArgs appends args after the built-in arguments of jupyter.
+func WithExtraArgs(args ...string) Option {
+	return func(jl *JupyterLash) { jl.extraArgs = append(jl.extraArgs, args...) }
+}
+
+// WithKernelLogs writes the jupyter log lines of each kernel to kernel-<id>.log in dir,
+// the lines are also logged with the kernel field.
+func WithKernelLogs(dir string) Option {
+	return func(jl *JupyterLash) { jl.kernelLogs = &kernelLogs{dir: dir} }
+}
+
+// WithLogBuffer serves the lines of b at /logs of the admin api,
+// and writes its tail to the status file. b is set to the loggers by the caller.
+func WithLogBuffer(b *LogBuffer) Option {
+	return func(jl *JupyterLash) { jl.status.logs = b }
+}
+
+// WithReadyLine writes a ReadyLine to w once jupyter is ready.
+func WithReadyLine(w io.Writer) Option {
+	return func(jl *JupyterLash) { jl.readyW = w }
+}
+
+// WithOpenBrowser opens the server url in the default browser once jupyter is ready.
+func WithOpenBrowser() Option {
+	return func(jl *JupyterLash) { jl.openURL = true }
+}
+
+// WithStatusFile sets the JSON file to write the status.
+func WithStatusFile(path string) Option {
+	return func(jl *JupyterLash) { jl.status.path = path }
+}
+
+// WithLogger sets the logger, default is a text *StdLogger to stdout and stderr.
+func WithLogger(lg Logger) Option {
+	return func(jl *JupyterLash) { jl.lg = lg }
+}
+
+// WithJupyterLogger sets the logger of jupyter's output, default is the logger.
+func WithJupyterLogger(lg Logger) Option {
+	return func(jl *JupyterLash) { jl.jupyterLg = lg }
+}
+
+// WithDiscardStdout does not log jupyter's stdout, its stderr is logged as is.
+func WithDiscardStdout() Option {
+	return func(jl *JupyterLash) { jl.discardStdout = true }
+}
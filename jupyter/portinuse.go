@@ -0,0 +1,18 @@
+package jupyter
+
+import (
+	"regexp"
+)
+
+// portInUseRegexp matches the errors of a busy port in jupyter's stderr,
+// e.g. "OSError: [Errno 98] Address already in use" or
+// "ERROR: the Jupyter server could not be started because port 8888 is not available."
+// or "Bind for 127.0.0.1:8888 failed: port is already allocated" of docker.
+var portInUseRegexp = regexp.MustCompile(`(?i)address already in use|errno (98|48|10048)\b|winerror 10048|port \d+ is (not available|already in use)|port is already allocated`)
+
+// scanPortInUse remembers that jupyter failed to listen on the port
+func (jl *JupyterLash) scanPortInUse(line string) {
+	if portInUseRegexp.MatchString(line) {
+		jl.portInUse.Store(true)
+	}
+}
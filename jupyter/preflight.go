@@ -0,0 +1,402 @@
+package jupyter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const preflightTimeout = 30 * time.Second
+
+// CheckWritable writes and removes a temp file in the notebook dir,
+// a read-only mount passes the permission bits but fails the first save.
+func CheckWritable(notebookDir string) error {
+	f, err := os.CreateTemp(notebookDir, ".neo-jupyter-check-*")
+	if err != nil {
+		return fmt.Errorf("notebook dir not writable: %w", err)
+	}
+	name := f.Name()
+	err = f.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return fmt.Errorf("notebook dir not writable: %w", err)
+	}
+	return nil
+}
+
+var versionRegexp = regexp.MustCompile(`\d+\.\d+(\.\d+)?\S*`)
+
+// minPythonMajor and minPythonMinor are the oldest python that jupyterlab runs on
+const minPythonMajor, minPythonMinor = 3, 8
+
+// checkPythonVersion returns an error if ver is older than the minimum python
+func checkPythonVersion(ver string) error {
+	parts := strings.SplitN(ver, ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("unexpected version %q", ver)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("unexpected version %q", ver)
+	}
+	minor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return fmt.Errorf("unexpected version %q", ver)
+	}
+	if major < minPythonMajor || major == minPythonMajor && minor < minPythonMinor {
+		return fmt.Errorf("python %d.%d or later is required", minPythonMajor, minPythonMinor)
+	}
+	return nil
+}
+
+// Preflight resolves python and jupyter executables, checks that the
+// module of the ui is importable by the python, and caches the detected version.
+// If install is enabled, a missing module is installed with pip,
+// then the requirements are installed,
+// and the machbase sql kernel is registered if install kernel is enabled.
+// With a docker image, it checks only the docker CLI.
+// It fails first if the notebook dir is not writable, unless the checks are skipped.
+func (jl *JupyterLash) Preflight() error {
+	if !jl.skipChecks {
+		if err := CheckWritable(jl.notebookDir); err != nil {
+			return err
+		}
+	}
+	if jl.dockerImage != "" {
+		return jl.dockerPreflight()
+	}
+	jl.checkAccount()
+	if err := jl.resolveExecutables(); err != nil {
+		return err
+	}
+	pythonVer, err := jl.detectVersion("--version")
+	if err != nil {
+		jl.logError("fail to detect python version: %v", err)
+	} else if err := checkPythonVersion(pythonVer); err != nil {
+		name := jl.pythonBin
+		if jl.condaEnv != "" {
+			name = "python of conda env " + jl.condaEnv
+		}
+		if !jl.pythonDiscovered {
+			// the user asked for this python
+			jl.logWarn("%s is python %s: %v, using it as given", name, pythonVer, err)
+		} else {
+			return fmt.Errorf("%s is python %s: %v, please specify another python with -python", name, pythonVer, err)
+		}
+	}
+	u := uis[jl.ui]
+	ver, err := jl.detectModuleVersion(u.module)
+	// offline, report all the missing packages at once instead of installing them
+	var missing []string
+	if err != nil && jl.offline {
+		missing = append(missing, u.pkg)
+	} else if err != nil && jl.install {
+		jl.log("%s is not available, installing...", u.module)
+		if err := jl.pipInstall(u.pkg); err != nil {
+			return fmt.Errorf("fail to install %s: %v", u.pkg, err)
+		}
+		ver, err = jl.detectModuleVersion(u.module)
+	}
+	if err != nil && !jl.offline {
+		return fmt.Errorf("%s is not available: %v\nplease install it with: pip install %s", u.module, err, u.pkg)
+	}
+	if jl.collaborative && err == nil {
+		pkg, err := jl.prepareCollaboration(ver)
+		if err != nil {
+			return fmt.Errorf("fail to install %s: %v", collaborationPkg, err)
+		}
+		if pkg != "" {
+			missing = append(missing, pkg)
+		}
+	}
+	exts, err := jl.configureExtensions()
+	if err != nil {
+		return err
+	}
+	missing = append(missing, exts...)
+	if jl.offline {
+		reqs, err := jl.offlineRequirements()
+		if err != nil {
+			return err
+		}
+		missing = append(missing, reqs...)
+		if len(missing) > 0 {
+			return fmt.Errorf("offline, not installing the missing packages: %s", strings.Join(missing, ", "))
+		}
+	} else if err := jl.installRequirements(); err != nil {
+		return err
+	}
+	if jl.kernel {
+		if err := jl.installKernel(); err != nil {
+			return fmt.Errorf("fail to install kernel: %v", err)
+		}
+	}
+	jl.Lock()
+	defer jl.Unlock()
+	jl.pythonVersion = pythonVer
+	jl.uiVersion = ver
+	jl.log("python %s, %s %s", pythonVer, u.module, ver)
+	if jl.jupyterBin == "" && jl.condaEnv == "" {
+		if jl.venv != "" {
+			_, jl.jupyterBin, _ = venvExecutables(jl.venv)
+		} else if _, jupyter, err := discoverExecutables(false, true, jl.discoveryTimeout); err == nil {
+			jl.jupyterBin = jupyter
+		}
+		if jl.jupyterBin != "" {
+			jl.log("jupyter: %s", jl.jupyterBin)
+		}
+	}
+	return nil
+}
+
+// resolveExecutables validates the configured python and jupyter,
+// and discovers them if they are not configured.
+func (jl *JupyterLash) resolveExecutables() error {
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.venv != "" && jl.condaEnv != "" {
+		return errors.New("venv and conda env are mutually exclusive")
+	}
+	if jl.condaEnv != "" {
+		if jl.condaBin == "" {
+			conda, err := findConda(jl.condaEnv)
+			if err != nil {
+				return fmt.Errorf("invalid conda env: %w", err)
+			}
+			jl.condaBin = conda
+		}
+		jl.log("conda: %s env: %s", jl.condaBin, jl.condaEnv)
+		return nil
+	}
+	if jl.venv != "" {
+		python, jupyter, err := venvExecutables(jl.venv)
+		if err != nil {
+			return fmt.Errorf("invalid venv: %w", err)
+		}
+		if jl.pythonBin == "" {
+			jl.pythonBin = python
+		}
+		if jl.jupyterBin == "" {
+			jl.jupyterBin = jupyter
+		}
+	}
+	findPython, findJupyter := jl.pythonBin == "", jl.jupyterBin == ""
+	jl.pythonDiscovered = findPython
+	if findPython || findJupyter {
+		python, jupyter, err := discoverExecutables(findPython, findJupyter, jl.discoveryTimeout)
+		if err != nil {
+			return err
+		}
+		if findPython {
+			jl.pythonBin = python
+		}
+		if findJupyter {
+			jl.jupyterBin = jupyter
+		}
+	}
+	if jl.pythonBin == "" {
+		return errors.New("python not found")
+	}
+	if !findPython {
+		if err := checkExecutable(jl.pythonBin); err != nil {
+			return fmt.Errorf("invalid python: %w", err)
+		}
+	}
+	if jl.jupyterBin == "" && !jl.install {
+		return errors.New("jupyter not found")
+	}
+	if !findJupyter {
+		if err := checkExecutable(jl.jupyterBin); err != nil {
+			return fmt.Errorf("invalid jupyter: %w", err)
+		}
+	}
+	// jupyter runs in the notebook dir, a relative path would be resolved there
+	jl.pythonBin, jl.jupyterBin = absPath(jl.pythonBin), absPath(jl.jupyterBin)
+	jl.log("python: %s", jl.pythonBin)
+	if jl.jupyterBin != "" {
+		jl.log("jupyter: %s", jl.jupyterBin)
+	}
+	return nil
+}
+
+// absPath returns path as an absolute path if it has a directory,
+// a bare name is looked up in PATH as is.
+func absPath(path string) string {
+	if path == "" || filepath.Base(path) == path {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// pipInstall runs pip install with args, output is forwarded to the log
+func (jl *JupyterLash) pipInstall(args ...string) error {
+	if jl.offline {
+		return fmt.Errorf("offline, not installing %s", strings.Join(args, " "))
+	}
+	if err := jl.checkPipIndex(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jl.installTimeout)
+	defer cancel()
+	pipArgs := []string{"-m", "pip", "install"}
+	if jl.pipIndex != "" {
+		pipArgs = append(pipArgs, "--index-url", jl.pipIndex)
+	}
+	cmd := jl.pythonCommand(ctx, append(pipArgs, args...)...)
+	if err := jl.runLogged(cmd); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timeout after %s", jl.installTimeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// RequirementsFile is installed from the notebook dir if no requirements file is set
+const RequirementsFile = "requirements.txt"
+
+// installRequirements installs the requirements file with pip if install is enabled.
+// The error wraps *exec.ExitError of pip if it failed.
+func (jl *JupyterLash) installRequirements() error {
+	path := jl.requirementsPath()
+	if path == "" {
+		return nil
+	}
+	if !jl.install {
+		jl.logWarn("requirements %s are not installed without install enabled", path)
+		return nil
+	}
+	jl.log("installing requirements %s...", path)
+	if err := jl.pipInstall("-r", path); err != nil {
+		return fmt.Errorf("fail to install requirements %s: %w", path, err)
+	}
+	return nil
+}
+
+// offlineRequirements returns the requirements that are not installed
+func (jl *JupyterLash) offlineRequirements() ([]string, error) {
+	path := jl.requirementsPath()
+	if path == "" {
+		return nil, nil
+	}
+	missing, err := jl.missingRequirements(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to check requirements %s: %w", path, err)
+	}
+	return missing, nil
+}
+
+// requirementsPath returns the requirements file, empty if there is none
+func (jl *JupyterLash) requirementsPath() string {
+	if jl.requirements != "" {
+		return jl.requirements
+	}
+	path := filepath.Join(jl.notebookDir, RequirementsFile)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// runLogged runs cmd, forwarding its stdout to log and stderr to logError
+func (jl *JupyterLash) runLogged(cmd *exec.Cmd) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		forwardLines(stdout, jl.log)
+	}()
+	go func() {
+		defer wg.Done()
+		forwardLines(stderr, jl.logError)
+	}()
+	wg.Wait()
+	return cmd.Wait()
+}
+
+// forwardLines calls logf for each line of r until EOF
+func forwardLines(r io.Reader, logf func(string, ...any)) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		logf("%s", s.Text())
+	}
+	// keep draining so that the writer never blocks on a too long line
+	io.Copy(io.Discard, r)
+}
+
+// Versions are the versions detected by Preflight() or DetectVersions()
+type Versions struct {
+	Python  string
+	UI      string // python module of the ui, e.g. jupyterlab
+	Jupyter string // version of the UI module
+}
+
+// Versions returns the versions detected by Preflight()
+func (jl *JupyterLash) Versions() Versions {
+	jl.RLock()
+	defer jl.RUnlock()
+	return Versions{Python: jl.pythonVersion, UI: uis[jl.ui].module, Jupyter: jl.uiVersion}
+}
+
+// DetectVersions resolves the executables and detects the versions without installing anything,
+// a version that can not be detected is empty.
+func (jl *JupyterLash) DetectVersions() (Versions, error) {
+	if err := jl.resolveExecutables(); err != nil {
+		return Versions{UI: uis[jl.ui].module}, err
+	}
+	pythonVer, _ := jl.detectVersion("--version")
+	ver, _ := jl.detectModuleVersion(uis[jl.ui].module)
+	jl.Lock()
+	jl.pythonVersion, jl.uiVersion = pythonVer, ver
+	jl.Unlock()
+	return jl.Versions(), nil
+}
+
+func (jl *JupyterLash) detectModuleVersion(module string) (string, error) {
+	return jl.detectVersion("-m", module, "--version")
+}
+
+// detectVersion runs python with args and returns the version in the output
+func (jl *JupyterLash) detectVersion(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := jl.pythonCommand(ctx, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	ver := versionRegexp.FindString(string(out))
+	if ver == "" {
+		return "", fmt.Errorf("unexpected version output %q", strings.TrimSpace(string(out)))
+	}
+	return ver, nil
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package jupyter
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	hasParentDeathSignal = true
+	hasResourceLimits    = true
+)
+
+// setParentDeathSignal makes the kernel send SIGTERM to jupyter when
+// neo-jupyter dies without stopping it. Only jupyter, not the whole process
+// group, receives it, and jupyter shuts down its kernels by itself, so it does
+// not overlap with the group signal sent on a normal stop.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGTERM
+}
+
+// rlimitNproc is RLIMIT_NPROC, the syscall package does not define it
+var rlimitNproc = func() int {
+	if strings.HasPrefix(runtime.GOARCH, "mips") {
+		return 8
+	}
+	return 6
+}()
+
+type rlimit64 struct {
+	cur uint64
+	max uint64
+}
+
+// setResourceLimits sets RLIMIT_AS and RLIMIT_NPROC of the started jupyter with prlimit(2),
+// SysProcAttr has no field for them. Kernels and terminals inherit the limits.
+// RLIMIT_NPROC counts all processes of the user, not only the children of jupyter.
+func setResourceLimits(pid int, maxMemory int64, maxProcs int) error {
+	if maxMemory > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_AS, uint64(maxMemory)); err != nil {
+			return fmt.Errorf("RLIMIT_AS: %w", err)
+		}
+	}
+	if maxProcs > 0 {
+		if err := prlimit(pid, rlimitNproc, uint64(maxProcs)); err != nil {
+			return fmt.Errorf("RLIMIT_NPROC: %w", err)
+		}
+	}
+	return nil
+}
+
+func prlimit(pid int, resource int, limit uint64) error {
+	lim := rlimit64{cur: limit, max: limit}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
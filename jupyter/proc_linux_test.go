@@ -0,0 +1,29 @@
+package jupyter
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestParentDeathSignal(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		opts := []Option{WithStatusFile(filepath.Join(t.TempDir(), "status.json"))}
+		want := syscall.SIGTERM
+		if reuse {
+			// the jupyter outlives the launcher to be reused
+			opts, want = append(opts, WithReuse()), 0
+		}
+		jl := newFake(t, "serve", opts...)
+		if err := jl.Start(); err != nil {
+			t.Fatal(err)
+		}
+		jl.RLock()
+		sig := jl.proc.cmd.SysProcAttr.Pdeathsig
+		jl.RUnlock()
+		if sig != want {
+			t.Errorf("reuse %v: parent death signal %v, want %v", reuse, sig, want)
+		}
+		jl.Stop()
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !windows
+
+package jupyter
+
+import "syscall"
+
+// there is no parent death signal, orphans are reaped by reapOrphan() on the next start
+const hasParentDeathSignal = false
+
+// resource limits are linux only, setResourceLimits does nothing
+const hasResourceLimits = false
+
+func setResourceLimits(pid int, maxMemory int64, maxProcs int) error {
+	return nil
+}
+
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+}
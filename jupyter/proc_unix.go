@@ -0,0 +1,72 @@
+//go:build !windows
+
+package jupyter
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcAttr starts jupyter in its own process group,
+// so that its kernels can be signaled together. Without deathSignal
+// jupyter outlives neo-jupyter, so that the next one can reuse it.
+func setProcAttr(cmd *exec.Cmd, deathSignal bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	if deathSignal {
+		setParentDeathSignal(cmd.SysProcAttr)
+	}
+}
+
+// interruptProcess sends sig to the process group of p
+func interruptProcess(p *os.Process, sig syscall.Signal) error {
+	return syscall.Kill(-p.Pid, sig)
+}
+
+// killProcess sends SIGKILL to the process group of p
+func killProcess(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+func isExecutable(path string, fi os.FileInfo) bool {
+	return fi.Mode()&0111 != 0
+}
+
+// ProcessAlive reports whether the process of pid exists
+func ProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	// EPERM means the process exists but belongs to another user
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// setCredential runs cmd as the account with its supplementary groups
+func setCredential(cmd *exec.Cmd, a *Account) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	groups := make([]uint32, len(a.Groups))
+	for i, g := range a.Groups {
+		groups[i] = uint32(g)
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    uint32(a.Uid),
+		Gid:    uint32(a.Gid),
+		Groups: groups,
+	}
+}
+
+// fileOwner returns the uid and gid of the owner of fi
+func fileOwner(fi os.FileInfo) (int, int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
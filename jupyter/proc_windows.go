@@ -0,0 +1,77 @@
+//go:build windows
+
+package jupyter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// there is no parent death signal, orphans are reaped by reapOrphan() on the next start
+const hasParentDeathSignal = false
+
+// resource limits are linux only, setResourceLimits does nothing
+const hasResourceLimits = false
+
+func setResourceLimits(pid int, maxMemory int64, maxProcs int) error {
+	return nil
+}
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+func setProcAttr(cmd *exec.Cmd, deathSignal bool) {
+	// a new process group is required to deliver CTRL_BREAK_EVENT to the child only
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// interruptProcess sends CTRL_BREAK_EVENT to the process group of p,
+// Windows has no equivalent of SIGTERM. If it fails, the process is killed.
+func interruptProcess(p *os.Process, sig syscall.Signal) error {
+	r, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid))
+	if r == 0 {
+		p.Kill()
+		return err
+	}
+	return nil
+}
+
+// killProcess kills the process tree of p
+func killProcess(p *os.Process) error {
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+	if err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+func isExecutable(path string, fi os.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".exe", ".com", ".bat", ".cmd":
+		return true
+	}
+	return false
+}
+
+// ProcessAlive reports whether the process of pid exists
+func ProcessAlive(pid int) bool {
+	// FindProcess opens the process handle, that fails if there is no such process
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// there is no account on windows, LookupAccount fails
+func setCredential(cmd *exec.Cmd, a *Account) {}
+
+func fileOwner(fi os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
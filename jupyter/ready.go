@@ -0,0 +1,60 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ReadyLine is the JSON line written once when jupyter is first ready, so that
+// a parent process can wait for it without scraping the logs, e.g.
+//
+//	{"event":"ready","url":"http://127.0.0.1:8888/lab","port":8888,"pid":123,"jupyter_pid":124}
+//
+// The token in the url is masked as "...", the same as jupyter prints it. Port is of the listening port, it is not set
+// with a unix socket. Pid is of neo-jupyter and JupyterPid is of the jupyter process.
+// It is not written again when jupyter is restarted.
+type ReadyLine struct {
+	Event      string `json:"event"` // always "ready"
+	URL        string `json:"url"`
+	Port       int    `json:"port,omitempty"`
+	Sock       string `json:"sock,omitempty"`
+	Pid        int    `json:"pid"`
+	JupyterPid int    `json:"jupyter_pid,omitempty"`
+}
+
+// maskToken returns s with the token masked as "...", the same as jupyter prints it
+func (jl *JupyterLash) maskToken(s string) string {
+	if jl.token == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, jl.token, "...")
+}
+
+// writeReady writes the ready line once, u is the server url if it is printed
+func (jl *JupyterLash) writeReady(u string) {
+	if jl.readyW == nil {
+		return
+	}
+	jl.readyOnce.Do(func() {
+		st := jl.status.get()
+		if u == "" {
+			u = st.URL
+		}
+		if jl.external != nil {
+			u = jl.redactURL(jl.external)
+		} else if u == "" {
+			u = jl.url()
+		}
+		// the server url has the token put back by the url scanner
+		u = jl.maskToken(u)
+		line := ReadyLine{Event: "ready", URL: u, Port: st.Port, Sock: st.Sock, Pid: st.Pid, JupyterPid: st.JupyterPid}
+		if line.Sock != "" {
+			line.Port = 0
+		}
+		b, _ := json.Marshal(line)
+		if _, err := fmt.Fprintf(jl.readyW, "%s\n", b); err != nil {
+			jl.logError("fail to write the ready line: %v", err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package jupyter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Heartbeat is the JSON body posted to the report url
+type Heartbeat struct {
+	State  State   `json:"state"` // unhealthy while the health check is failing
+	URL    string  `json:"url,omitempty"`
+	Uptime float64 `json:"uptime"` // seconds since jupyter started, 0 if not running
+	Pid    int     `json:"pid"`
+}
+
+// StateUnhealthy is reported while the health check is failing, it is not a state of Status.
+const StateUnhealthy State = "unhealthy"
+
+var reportClient = &http.Client{Timeout: 5 * time.Second}
+
+// heartbeat returns the current heartbeat
+func (jl *JupyterLash) heartbeat() Heartbeat {
+	st := jl.status.get()
+	hb := Heartbeat{State: st.State, URL: st.URL, Pid: st.Pid}
+	if hb.State == StateRunning && jl.unhealthy.Load() {
+		hb.State = StateUnhealthy
+	}
+	if jl.external != nil {
+		hb.URL = jl.redactURL(jl.external)
+	}
+	if hb.State != StateStopped && !st.StartTime.IsZero() {
+		hb.Uptime = time.Since(st.StartTime).Round(time.Second).Seconds()
+	}
+	return hb
+}
+
+// postHeartbeat posts hb to the report url
+func (jl *JupyterLash) postHeartbeat(hb Heartbeat) error {
+	b, _ := json.Marshal(hb)
+	rsp, err := reportClient.Post(jl.reportURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", rsp.Status)
+	}
+	return nil
+}
+
+// reportLoop posts the heartbeat every interval until Stop() is called,
+// and a last one of the stopped state then, and closes done. Failures do not affect jupyter.
+func (jl *JupyterLash) reportLoop(stopC <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	tick := time.NewTicker(jl.reportInterval)
+	defer tick.Stop()
+	failing := false
+	for {
+		hb := jl.heartbeat()
+		stopped := false
+		select {
+		case <-stopC:
+			hb, stopped = Heartbeat{State: StateStopped, Pid: hb.Pid}, true
+		default:
+		}
+		err := jl.postHeartbeat(hb)
+		switch {
+		case err != nil && !failing:
+			jl.logWarn("fail to report to %s: %v", jl.reportURL, err)
+		case err != nil:
+			jl.logDebug("fail to report to %s: %v", jl.reportURL, err)
+		case failing:
+			jl.log("reporting to %s recovered", jl.reportURL)
+		}
+		failing = err != nil
+		if stopped {
+			return
+		}
+		select {
+		case <-stopC:
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package jupyter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// errNoPrevious means the status file names no jupyter to reuse
+var errNoPrevious = errors.New("no previous jupyter")
+
+const reusedCheckInterval = 500 * time.Millisecond
+
+// reusable returns the status of the jupyter in the status file if it is still
+// serving the same port, base url and token after its launcher is gone.
+func (jl *JupyterLash) reusable() (Status, error) {
+	st := Status{}
+	b, err := os.ReadFile(jl.status.path)
+	if err != nil {
+		return st, errNoPrevious
+	}
+	if err := json.Unmarshal(b, &st); err != nil || st.JupyterPid <= 0 || !ProcessAlive(st.JupyterPid) {
+		return st, errNoPrevious
+	}
+	switch {
+	case st.External:
+		return st, errors.New("it was not launched by neo-jupyter")
+	case st.Pid != os.Getpid() && ProcessAlive(st.Pid):
+		return st, fmt.Errorf("its launcher pid %d is running", st.Pid)
+	case st.Sock != "" || st.Port != jl.port || st.Bind != jl.bindIP || st.BaseURL != jl.baseURL:
+		return st, fmt.Errorf("it listens on %s:%d%s", st.Bind, st.Port, st.BaseURL)
+	case st.Token != jl.token:
+		return st, errors.New("its token is different")
+	}
+	if err := jl.checkHealth(jl.apiURL("api/status")); err != nil {
+		return st, fmt.Errorf("it does not respond: %w", err)
+	}
+	return st, nil
+}
+
+// reuse0 adopts the jupyter of st instead of launching one, the caller must hold the lock.
+// It is stopped and restarted the same as a launched one, but its output is not captured.
+func (jl *JupyterLash) reuse0(st Status) error {
+	p, err := os.FindProcess(st.JupyterPid)
+	if err != nil {
+		return err
+	}
+	// never started, only its Process is used to signal the reused one
+	proc := &process{cmd: &exec.Cmd{Path: "jupyter", Process: p}, done: make(chan struct{})}
+	stopC, ctx := jl.stopC, jl.ctx
+	jl.proc = proc
+	jl.startedAt = time.Now()
+	jl.status.update(func(st0 *Status) {
+		st0.Pid = os.Getpid()
+		st0.JupyterPid = st.JupyterPid
+		st0.Port = st.Port
+		st0.Bind = st.Bind
+		st0.BaseURL = st.BaseURL
+		st0.URL = st.URL
+		st0.Token = st.Token
+		st0.StartTime = st.StartTime
+		st0.State = StateRunning
+	})
+	if jl.portFile != "" {
+		if err := os.WriteFile(jl.portFile, []byte(strconv.Itoa(jl.port)), 0644); err != nil {
+			jl.logError("fail to write port file: %v", err)
+		}
+	}
+	jl.logEvent(levelInfo, map[string]any{"pid": st.JupyterPid}, "reusing jupyter pid %d on port %d, not launching a new one", st.JupyterPid, st.Port)
+	jl.publish(Event{Type: EventRunning, Pid: st.JupyterPid, URL: st.URL})
+	jl.writeReady(st.URL)
+	jl.watch(proc, st.JupyterPid, stopC)
+	go jl.waitReused(proc, ctx)
+	return nil
+}
+
+// waitReused waits for the reused jupyter to exit, that is polled as it is not a child.
+// The exit code is unknown, it is 0 if stopped by neo-jupyter and -1 otherwise.
+func (jl *JupyterLash) waitReused(proc *process, ctx context.Context) {
+	pid := proc.cmd.Process.Pid
+	tick := time.NewTicker(reusedCheckInterval)
+	defer tick.Stop()
+	for range tick.C {
+		if !ProcessAlive(pid) {
+			break
+		}
+	}
+	var err error
+	if !proc.signaled.Load() && ctx.Err() == nil {
+		proc.exitCode = -1
+		err = fmt.Errorf("reused jupyter pid %d exited", pid)
+	}
+	jl.setState(StateStopped)
+	proc.err = err
+	if err != nil {
+		jl.logEvent(levelError, map[string]any{"exit_code": proc.exitCode}, "fail to run: %v", err)
+	} else {
+		jl.logEvent(levelInfo, map[string]any{"exit_code": proc.exitCode}, "reused jupyter pid %d exited", pid)
+	}
+	close(proc.done)
+	jl.publish(Event{Type: EventExited, Pid: pid, ExitCode: proc.exitCode, Err: err})
+	jl.exited(proc, err)
+}
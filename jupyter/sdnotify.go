@@ -0,0 +1,63 @@
+package jupyter
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends the state to systemd, it is a no-op if NOTIFY_SOCKET is not set.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if path[0] == '@' {
+		// abstract namespace socket
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the interval to send WATCHDOG=1,
+// zero if the systemd watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdogLoop pings the systemd watchdog until Stop() is called,
+// and stops pinging while jupyter is unhealthy so that systemd restarts the unit.
+func (jl *JupyterLash) watchdogLoop(interval time.Duration, stopC <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-tick.C:
+		}
+		if jl.unhealthy.Load() {
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			jl.logError("fail to notify systemd watchdog: %v", err)
+		}
+	}
+}
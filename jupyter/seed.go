@@ -0,0 +1,59 @@
+package jupyter
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed seed
+var seedFS embed.FS
+
+// SeedNotebookDir copies the starter notebook and README into dir if it has no
+// files, hidden ones like .ipynb_checkpoints aside. It never overwrites a file,
+// and returns the names of the created files.
+func SeedNotebookDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ent := range entries {
+		if !strings.HasPrefix(ent.Name(), ".") {
+			return nil, nil
+		}
+	}
+	var created []string
+	err = fs.WalkDir(seedFS, "seed", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "seed" {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path, "seed/")))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		b, err := seedFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// O_EXCL, a file created meanwhile is kept
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		created = append(created, filepath.Base(dst))
+		return nil
+	})
+	return created, err
+}
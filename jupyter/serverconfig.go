@@ -0,0 +1,116 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// serverConfigFile is the config file that jupyter server loads from JUPYTER_CONFIG_DIR
+const serverConfigFile = "jupyter_server_config.json"
+
+// writeServerConfig renders the settings to the managed config dir instead of
+// the command line, and returns the dir to be JUPYTER_CONFIG_DIR of jupyter.
+func (jl *JupyterLash) writeServerConfig() (string, error) {
+	if jl.configDir == "" {
+		dir, err := os.MkdirTemp("", "neo-jupyter-config-")
+		if err != nil {
+			return "", err
+		}
+		jl.configDir = dir
+	}
+	b, err := json.MarshalIndent(jl.serverConfig(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// it has the token
+	if err := os.WriteFile(filepath.Join(jl.configDir, serverConfigFile), b, 0600); err != nil {
+		return "", err
+	}
+	if jl.account != nil {
+		if err := jl.account.Chown(jl.configDir); err != nil {
+			return "", err
+		}
+	}
+	return jl.configDir, nil
+}
+
+// removeServerConfig removes the managed config dir unless keep config is set
+func (jl *JupyterLash) removeServerConfig() {
+	if jl.configDir == "" || jl.keepConfig {
+		return
+	}
+	os.RemoveAll(jl.configDir)
+	jl.configDir = ""
+}
+
+// serverConfig returns the same settings as serverArgs() by class
+func (jl *JupyterLash) serverConfig() map[string]map[string]any {
+	cfg := map[string]map[string]any{
+		"ServerApp": {
+			"root_dir":            jl.notebookDir,
+			"ip":                  jl.bindIP,
+			"port":                jl.port,
+			"base_url":            jl.baseURL,
+			"allow_remote_access": jl.allowRemote,
+			"open_browser":        false,
+		},
+	}
+	if jl.sock != "" {
+		delete(cfg["ServerApp"], "ip")
+		delete(cfg["ServerApp"], "port")
+		cfg["ServerApp"]["sock"] = jl.sock
+	}
+	tokenClass := "ServerApp"
+	if jl.ui == "lab" {
+		tokenClass = "LabApp"
+	}
+	if cfg[tokenClass] == nil {
+		cfg[tokenClass] = map[string]any{}
+	}
+	cfg[tokenClass]["token"] = jl.token
+	if jl.certFile != "" && jl.keyFile != "" {
+		cfg["ServerApp"]["certfile"] = jl.certFile
+		cfg["ServerApp"]["keyfile"] = jl.keyFile
+	}
+	for k, v := range jl.settings {
+		class, name, _ := strings.Cut(k, ".")
+		if cfg[class] == nil {
+			cfg[class] = map[string]any{}
+		}
+		cfg[class][name] = configValue(v)
+	}
+	return cfg
+}
+
+// configValue converts a command line value to the JSON value,
+// e.g. True to true, 10 to a number and ['a'] to a list.
+func configValue(v string) any {
+	switch v {
+	case "True", "true":
+		return true
+	case "False", "false":
+		return false
+	case "None":
+		return nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	if strings.HasPrefix(v, "[") || strings.HasPrefix(v, "{") {
+		var ret any
+		if err := json.Unmarshal([]byte(v), &ret); err == nil {
+			return ret
+		}
+		// python literal with single quotes
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(v, "'", `"`)), &ret); err == nil {
+			return ret
+		}
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package jupyter
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// it matches http+unix:// of a unix socket too
+var serverURLRegexp = regexp.MustCompile(`(https?|http\+unix)://[^\s'"<>]+`)
+
+// serverURLScanner finds the server url in jupyter's output, e.g.
+//
+//	[I ServerApp] Jupyter Server 2.10.0 is running at:
+//	[I ServerApp] http://127.0.0.1:8888/web/apps/neo-jupyter/base/lab?token=...
+type serverURLScanner struct {
+	sync.Mutex
+	basePath string
+	token    string
+	running  bool // "is running at" line seen
+	url      string
+}
+
+// scan returns the url once when it is found in line
+func (s *serverURLScanner) scan(line string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.url != "" {
+		return "", false
+	}
+	if strings.Contains(line, "is running at") {
+		s.running = true
+	}
+	found := serverURLRegexp.FindString(line)
+	if found == "" {
+		return "", false
+	}
+	if !s.running && !strings.Contains(found, s.basePath) {
+		return "", false
+	}
+	// jupyter masks the token in some of the lines
+	if s.token != "" && strings.Contains(found, "token=...") {
+		found = strings.Replace(found, "token=...", "token="+s.token, 1)
+	}
+	s.url = found
+	return found, true
+}
+
+func (s *serverURLScanner) reset(basePath string, token string) {
+	s.Lock()
+	defer s.Unlock()
+	s.basePath, s.token = basePath, token
+	s.running, s.url = false, ""
+}
+
+func (s *serverURLScanner) URL() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.url
+}
+
+// ServerURL returns the url printed by jupyter, empty until it is ready.
+func (jl *JupyterLash) ServerURL() string {
+	return jl.urlScanner.URL()
+}
+
+func (jl *JupyterLash) scanServerURL(line string) {
+	if u, ok := jl.urlScanner.scan(line); ok {
+		masked := jl.maskToken(u)
+		jl.logEvent(levelInfo, map[string]any{"url": masked}, "jupyter ready: %s", masked)
+		jl.status.update(func(st *Status) {
+			st.URL = u
+			st.State = StateRunning
+		})
+		jl.publish(Event{Type: EventRunning, Pid: jl.status.get().JupyterPid, URL: u})
+		jl.writeReady(u)
+		if jl.openURL {
+			jl.openOnce.Do(func() { jl.openBrowser(u) })
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package jupyter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// prepareSocket creates the dir of the unix socket, and removes the socket file
+// left by a jupyter that did not exit cleanly. It fails if a server listens on it.
+func (jl *JupyterLash) prepareSocket() error {
+	dir := filepath.Dir(jl.sock)
+	_, err := os.Stat(dir)
+	created := errors.Is(err, os.ErrNotExist)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("fail to create socket dir: %w", err)
+	}
+	// jupyter creates the socket as the account
+	if created && jl.account != nil {
+		if err := jl.account.Chown(dir); err != nil {
+			return fmt.Errorf("fail to create socket dir: %w", err)
+		}
+	}
+	fi, err := os.Lstat(jl.sock)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", jl.sock)
+	}
+	if conn, err := net.DialTimeout("unix", jl.sock, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", jl.sock)
+	}
+	jl.log("removing stale socket %s", jl.sock)
+	return os.Remove(jl.sock)
+}
+
+// removeSocket removes the socket file if jupyter left it
+func (jl *JupyterLash) removeSocket() {
+	if jl.sock == "" {
+		return
+	}
+	if fi, err := os.Lstat(jl.sock); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(jl.sock)
+	}
+}
+
+// sockURL returns the url of jupyter on the unix socket, as jupyter prints it
+func (jl *JupyterLash) sockURL() string {
+	return "http+unix://" + url.PathEscape(jl.sock) + jl.baseURL
+}
+
+// httpClient returns the client to reach jupyter, over the unix socket if set
+func (jl *JupyterLash) httpClient() *http.Client {
+	if jl.sock == "" {
+		return healthClient
+	}
+	jl.sockClientOnce.Do(func() {
+		sock := jl.sock
+		jl.sockClient = &http.Client{
+			Timeout: healthClient.Timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+				},
+			},
+		}
+	})
+	return jl.sockClient
+}
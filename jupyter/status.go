@@ -0,0 +1,112 @@
+package jupyter
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateStopped  State = "stopped"
+	StateStarting State = "starting" // launched, the server url is not printed yet
+	StateRunning  State = "running"
+	// the external jupyter does not respond to the health check
+	StateUnreachable State = "unreachable"
+)
+
+// Status is written to the status file as JSON
+type Status struct {
+	Pid        int       `json:"pid"`
+	JupyterPid int       `json:"jupyter_pid,omitempty"`
+	Port       int       `json:"port"`
+	Bind       string    `json:"bind"`
+	Sock       string    `json:"sock,omitempty"`
+	BaseURL    string    `json:"base_url"`
+	URL        string    `json:"url,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	State      State     `json:"state"`
+	External   bool      `json:"external,omitempty"` // monitoring a jupyter not launched by neo-jupyter
+	Logs       []LogLine `json:"logs,omitempty"`     // last log lines, only in the status file
+}
+
+// statusFile keeps the status and writes it to path on every update
+type statusFile struct {
+	sync.Mutex
+	path   string
+	status Status
+	logs   *LogBuffer // its tail is written with the status if not nil
+}
+
+func (sf *statusFile) update(fn func(st *Status)) {
+	sf.Lock()
+	defer sf.Unlock()
+	fn(&sf.status)
+	if sf.path == "" {
+		return
+	}
+	st := sf.status
+	if sf.logs != nil {
+		st.Logs = sf.logs.Tail(statusLogLines)
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return
+	}
+	// write and rename, readers never see a partial file
+	tmp := sf.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, sf.path)
+}
+
+func (sf *statusFile) get() Status {
+	sf.Lock()
+	defer sf.Unlock()
+	return sf.status
+}
+
+func (sf *statusFile) remove() {
+	sf.Lock()
+	defer sf.Unlock()
+	if sf.path != "" {
+		os.Remove(sf.path)
+	}
+}
+
+// reapOrphan kills jupyter left running by a previous neo-jupyter that died,
+// according to its status file. It is a fallback if the jupyter is not stopped
+// by the parent death signal, that is not supported or not set with reuse.
+func reapOrphan(path string, deathSignal bool, lg Logger) {
+	if deathSignal || path == "" {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	st := Status{}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return
+	}
+	if st.JupyterPid <= 0 || st.Pid == os.Getpid() || ProcessAlive(st.Pid) || !ProcessAlive(st.JupyterPid) {
+		return
+	}
+	if p, err := os.FindProcess(st.JupyterPid); err == nil {
+		lg.Infof("killing orphaned jupyter pid %d", st.JupyterPid)
+		killProcess(p)
+	}
+}
+
+func (jl *JupyterLash) setState(state State) {
+	jl.status.update(func(st *Status) {
+		st.State = state
+		if state == StateStopped {
+			st.JupyterPid = 0
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package jupyter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Usage is the resource usage of jupyter and its descendant processes
+type Usage struct {
+	RSS       uint64        // resident memory in bytes
+	CPU       time.Duration // total cpu time, user and system
+	CPUPct    float64       // cpu usage since the previous sample, 100 is one core
+	Processes int
+	Time      time.Time
+}
+
+var errUsageUnsupported = errors.New("process usage is not supported on this platform")
+
+// Usage returns the last sample of usageLoop, ok is false if there is none
+func (jl *JupyterLash) Usage() (u Usage, ok bool) {
+	if p := jl.usage.Load(); p != nil {
+		return *p, true
+	}
+	return Usage{}, false
+}
+
+// usageLoop samples the usage of the process tree of pid every usageInterval
+// until the process exits or Stop() is called.
+func (jl *JupyterLash) usageLoop(pid int, exitC <-chan struct{}, stopC <-chan struct{}) {
+	defer jl.usage.Store(nil)
+	tick := time.NewTicker(jl.usageInterval)
+	defer tick.Stop()
+	var prev *Usage
+	for {
+		select {
+		case <-exitC:
+			return
+		case <-stopC:
+			return
+		case <-tick.C:
+		}
+		u, err := processTreeUsage(pid)
+		if err != nil {
+			if errors.Is(err, errUsageUnsupported) {
+				jl.logWarn("%v, usage sampling disabled", err)
+				return
+			}
+			jl.logDebug("usage: %v", err)
+			continue
+		}
+		u.Time = time.Now()
+		if prev != nil && u.Time.After(prev.Time) && u.CPU >= prev.CPU {
+			u.CPUPct = float64(u.CPU-prev.CPU) / float64(u.Time.Sub(prev.Time)) * 100
+		}
+		prev = &u
+		jl.usage.Store(&u)
+		jl.logEvent(levelInfo, map[string]any{"rss_bytes": u.RSS, "cpu_seconds": u.CPU.Seconds(), "cpu_percent": u.CPUPct, "processes": u.Processes},
+			"usage: rss %s, cpu %.1f%%, processes %d", formatBytes(u.RSS), u.CPUPct, u.Processes)
+	}
+}
+
+// formatBytes returns n in a human readable unit, e.g. "12.3 MiB"
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// descendants returns pid and its descendants in the parent map of pid to ppid
+func descendants(pid int, parents map[int]int) []int {
+	children := map[int][]int{}
+	for p, pp := range parents {
+		children[pp] = append(children[pp], p)
+	}
+	ret := []int{pid}
+	for i := 0; i < len(ret); i++ {
+		ret = append(ret, children[ret[i]]...)
+	}
+	return ret
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package jupyter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ of /proc/<pid>/stat, it is 100 on all linux architectures
+const clockTicks = 100
+
+type procStat struct {
+	ppid  int
+	utime uint64
+	stime uint64
+	rss   uint64 // pages
+}
+
+// processTreeUsage sums /proc/<pid>/stat of pid and its descendants,
+// so that kernels in other process groups are counted too.
+func processTreeUsage(pid int) (Usage, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return Usage{}, err
+	}
+	stats := map[int]procStat{}
+	parents := map[int]int{}
+	for _, ent := range entries {
+		p, err := strconv.Atoi(ent.Name())
+		if err != nil {
+			continue
+		}
+		// the process may exit while reading
+		st, err := readProcStat(p)
+		if err != nil {
+			continue
+		}
+		stats[p] = st
+		parents[p] = st.ppid
+	}
+	if _, ok := stats[pid]; !ok {
+		return Usage{}, fmt.Errorf("no process %d", pid)
+	}
+	var u Usage
+	var ticks uint64
+	for _, p := range descendants(pid, parents) {
+		st := stats[p]
+		ticks += st.utime + st.stime
+		u.RSS += st.rss * uint64(os.Getpagesize())
+		u.Processes++
+	}
+	u.CPU = time.Duration(ticks) * time.Second / clockTicks
+	return u, nil
+}
+
+func readProcStat(pid int) (procStat, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	// the command name in parentheses may contain spaces
+	s := string(b)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return procStat{}, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	// fields after the name start from the 3rd, state
+	f := strings.Fields(s[i+1:])
+	if len(f) < 22 {
+		return procStat{}, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	var st procStat
+	st.ppid, _ = strconv.Atoi(f[1])
+	st.utime, _ = strconv.ParseUint(f[11], 10, 64)
+	st.stime, _ = strconv.ParseUint(f[12], 10, 64)
+	st.rss, _ = strconv.ParseUint(f[21], 10, 64)
+	return st, nil
+}
@@ -0,0 +1,64 @@
+//go:build !linux && !windows
+
+package jupyter
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processTreeUsage sums the ps output of pid and its descendants, best effort
+func processTreeUsage(pid int) (Usage, error) {
+	out, err := exec.Command("ps", "-A", "-o", "pid=,ppid=,rss=,time=").Output()
+	if err != nil {
+		return Usage{}, fmt.Errorf("ps: %w", err)
+	}
+	type psLine struct {
+		rss uint64 // KiB
+		cpu time.Duration
+	}
+	lines := map[int]psLine{}
+	parents := map[int]int{}
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 4 {
+			continue
+		}
+		p, err1 := strconv.Atoi(f[0])
+		pp, err2 := strconv.Atoi(f[1])
+		rss, err3 := strconv.ParseUint(f[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		lines[p] = psLine{rss: rss, cpu: parseCPUTime(f[3])}
+		parents[p] = pp
+	}
+	if _, ok := lines[pid]; !ok {
+		return Usage{}, fmt.Errorf("no process %d", pid)
+	}
+	var u Usage
+	for _, p := range descendants(pid, parents) {
+		u.RSS += lines[p].rss * 1024
+		u.CPU += lines[p].cpu
+		u.Processes++
+	}
+	return u, nil
+}
+
+// parseCPUTime parses the ps time, "[[dd-]hh:]mm:ss[.ss]"
+func parseCPUTime(s string) time.Duration {
+	var days int
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		days, _ = strconv.Atoi(d)
+		s = rest
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		secs = secs*60 + v
+	}
+	return time.Duration((float64(days)*86400 + secs) * float64(time.Second))
+}
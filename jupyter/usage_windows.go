@@ -0,0 +1,7 @@
+//go:build windows
+
+package jupyter
+
+func processTreeUsage(pid int) (Usage, error) {
+	return Usage{}, errUsageUnsupported
+}
@@ -0,0 +1,87 @@
+package jupyter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func venvBinDir(venv string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(venv, "Scripts")
+	}
+	return filepath.Join(venv, "bin")
+}
+
+// venvExecutables returns python and jupyter of the virtualenv,
+// jupyter is empty if it is not installed in the virtualenv.
+func venvExecutables(venv string) (string, string, error) {
+	if _, err := os.Stat(filepath.Join(venv, "pyvenv.cfg")); err != nil {
+		return "", "", fmt.Errorf("%s is not a virtualenv: %w", venv, err)
+	}
+	bin := venvBinDir(venv)
+	python, jupyter := filepath.Join(bin, "python"), filepath.Join(bin, "jupyter")
+	if runtime.GOOS == "windows" {
+		python, jupyter = python+".exe", jupyter+".exe"
+	}
+	if err := checkExecutable(python); err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(jupyter); err != nil {
+		jupyter = ""
+	}
+	return python, jupyter, nil
+}
+
+// venvEnviron returns env activated for the virtualenv
+func venvEnviron(venv string, env []string) []string {
+	ret := make([]string, 0, len(env)+2)
+	path := venvBinDir(venv)
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		switch {
+		case strings.EqualFold(k, "PATH"):
+			path = path + string(filepath.ListSeparator) + v
+		case k == "VIRTUAL_ENV", k == "PYTHONHOME":
+		default:
+			ret = append(ret, kv)
+		}
+	}
+	return append(ret, "PATH="+path, "VIRTUAL_ENV="+venv)
+}
+
+// findConda returns the conda executable if the conda environment name exists
+func findConda(name string) (string, error) {
+	conda := os.Getenv("CONDA_EXE")
+	if conda == "" {
+		conda = lookPath("conda")
+	}
+	if conda == "" {
+		return "", fmt.Errorf("conda not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, conda, "env", "list", "--json").Output()
+	if err != nil {
+		return "", fmt.Errorf("conda env list: %w", err)
+	}
+	envs := struct {
+		Envs []string `json:"envs"`
+	}{}
+	if err := json.Unmarshal(out, &envs); err != nil {
+		return "", fmt.Errorf("conda env list: %w", err)
+	}
+	for i, env := range envs.Envs {
+		// the first one is the root prefix, which is named "base"
+		if filepath.Base(env) == name || (i == 0 && name == "base") {
+			return conda, nil
+		}
+	}
+	return "", fmt.Errorf("conda env %q not found", name)
+}
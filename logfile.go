@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotateFile is a log file that is rotated when it exceeds maxSize,
+// keeping at most maxBackups of path.1, path.2, ...
+type rotateFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotateFile(path string, maxSize int64, maxBackups int) (*rotateFile, error) {
+	rf := &rotateFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotateFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotateFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	if rf.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotateFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotateFile) Close() error {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
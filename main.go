@@ -1,188 +1,1100 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
+
+	"neo-jupyter/jupyter"
 )
 
+// version is set by -ldflags "-X main.version=..."
+var version = "dev"
+
+var lg = jupyter.NewStdLogger("text", os.Stdout, os.Stderr)
+
+// fatalf logs the message as an error and exits with status 1
+func fatalf(f string, args ...any) {
+	lg.Errorf(f, args...)
+	os.Exit(1)
+}
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	pid := flag.String("pid", "neo-jupyter.pid", "pid file")
+	port := flag.Int("port", 8888, "jupyter listen port, overrides MACHBASE_NEO_JUPYTER_PORT")
+	portAuto := flag.Bool("port-auto", false, "find a free port if the configured port is in use")
+	portRange := flag.Int("port-range", 100, "number of ports to scan upward with -port-auto")
+	portFile := flag.String("port-file", "", "file to write the selected port")
+	ui := flag.String("ui", "lab", "jupyter interface: lab, notebook, server")
+	baseURL := flag.String("base-url", jupyter.DefaultBaseURL, "jupyter base url path")
+	sock := flag.String("sock", "", "unix socket for jupyter to listen on instead of -port and -bind, ServerApp.sock")
+	bind := flag.String("bind", "127.0.0.1", "jupyter listen ip address, IPv4 or IPv6, e.g. ::1 or :: for all")
+	token := flag.String("token", "", "jupyter access token, 'auto' to generate one that is written to the -status-file (default disabled)")
+	certFile := flag.String("certfile", "", "TLS certificate file")
+	keyFile := flag.String("keyfile", "", "TLS private key file")
+	restart := flag.Bool("restart", false, "restart jupyter when it exits unexpectedly")
+	restartMax := flag.Int("restart-max", 0, "max consecutive restart failures before giving up (0: unlimited)")
+	healthInterval := flag.Duration("health-interval", 0, "interval of jupyter health check (0: disabled)")
+	healthRetries := flag.Int("health-retries", 3, "consecutive health check failures before restarting")
+	maxMemory := flag.Int64("max-memory", 0, "address space limit of jupyter and its kernels in bytes, linux only (0: unlimited)")
+	maxProcs := flag.Int("max-procs", 0, "process limit of the user running jupyter, linux only (0: unlimited)")
+	drain := flag.Duration("drain", 0, "on restart, wait up to the duration for the kernels to be idle before stopping jupyter (0: disabled)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "stop jupyter after no active kernels and sessions for the duration (0: disabled)")
+	usageInterval := flag.Duration("usage-interval", 0, "interval to log the memory and cpu usage of jupyter and its kernels (0: disabled)")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve prometheus /metrics, e.g. 127.0.0.1:9888 (default disabled)")
+	adminAddr := flag.String("admin-addr", "", "address to serve the admin api, e.g. 127.0.0.1:9889 (default disabled)")
+	adminToken := flag.String("admin-token", "", "bearer token of the admin api, required with -admin-addr")
+	reportURL := flag.String("report-url", "", "url to POST a JSON heartbeat of the state, url and uptime of jupyter (default disabled)")
+	reportInterval := flag.Duration("report-interval", 30*time.Second, "interval of the -report-url heartbeat")
+	dockerImage := flag.String("docker-image", "", "run jupyter of the docker image instead of the local python, e.g. quay.io/jupyter/base-notebook")
+	runUser := flag.String("user", "", "user name or uid to run jupyter as, requires root")
+	runGroup := flag.String("group", "", "group name or gid to run jupyter as with -user (default the primary group of the user)")
+	allowRoot := flag.Bool("allow-root", false, "allow running jupyter as root without -user")
+	externalURL := flag.String("external-url", "", "monitor the jupyter running at the url instead of launching one, e.g. http://127.0.0.1:8888/base/")
+	pythonBin := flag.String("python", "", "python interpreter path, overrides MACHBASE_NEO_PYTHON")
+	jupyterBin := flag.String("jupyter", "", "jupyter executable path, overrides MACHBASE_NEO_JUPYTER")
+	discoveryTimeout := flag.Duration("discovery-timeout", 10*time.Second, "timeout to find python and jupyter")
+	venv := flag.String("venv", "", "virtualenv root directory to run jupyter in")
+	condaEnv := flag.String("conda-env", "", "conda environment name to run jupyter in")
+	install := flag.Bool("install", false, "install jupyterlab with pip if it is missing")
+	installKernel := flag.Bool("install-kernel", false, "register the machbase sql kernel if it is not installed")
+	requirements := flag.String("requirements", "", "requirements file to install with -install (default requirements.txt in the notebook dir if exists)")
+	offline := flag.Bool("offline", false, "never install with pip, fail listing the missing packages instead")
+	pipIndex := flag.String("pip-index", "", "pip index url for -install")
+	installTimeout := flag.Duration("install-timeout", 10*time.Minute, "timeout of -install")
+	skipChecks := flag.Bool("skip-checks", false, "skip the check that the notebook dir is writable")
+	seed := flag.Bool("seed", false, "create a starter notebook and README in an empty notebook dir")
+	nbDir := flag.String("notebook-dir", "", "notebook directory, overrides MACHBASE_NEO_FILE (default the first usable dir of MACHBASE_NEO_FILE, or the current directory)")
+	var jupyterArgs stringList
+	flag.Var(&jupyterArgs, "jupyter-arg", "extra argument passed to jupyter after the built-in ones, repeatable (args after '--' are also passed)")
+	dataDir := flag.String("data-dir", "", "JUPYTER_DATA_DIR of jupyter (default per-instance dir in the user cache dir)")
+	runtimeDir := flag.String("runtime-dir", "", "JUPYTER_RUNTIME_DIR of jupyter (default per-instance dir in the user cache dir)")
+	var pythonPaths stringList
+	flag.Var(&pythonPaths, "pythonpath", "path to prepend to PYTHONPATH of jupyter, repeatable or list-separated")
+	var envs stringList
+	flag.Var(&envs, "env", "KEY=VALUE environment variable of jupyter, repeatable")
+	envFile := flag.String("env-file", "", ".env file of KEY=VALUE environment variables of jupyter, -env takes precedence")
+	var sets stringList
+	flag.Var(&sets, "set", "jupyter setting key=value as --ServerApp.key=value, or --Class.key=value if key has a class prefix, repeatable")
+	genConfig := flag.Bool("gen-config", false, "pass the settings in a generated jupyter_server_config.json instead of the command line")
+	keepConfig := flag.Bool("keep-config", false, "keep the generated config after stop")
+	var allowOrigins stringList
+	flag.Var(&allowOrigins, "allow-origin", "origin allowed to access jupyter, '*' or a regular expression, repeatable (default same origin only)")
+	allowRemote := flag.Bool("allow-remote", true, "allow access with a non-local Host header, ServerApp.allow_remote_access")
+	var enableExts, disableExts stringList
+	flag.Var(&enableExts, "enable-ext", "lab extension or plugin to enable, name=pip-package to install it with -install if missing, repeatable")
+	flag.Var(&disableExts, "disable-ext", "lab extension or plugin to disable, repeatable")
+	appTitle := flag.String("app-title", "", "app name of the browser tab and the ui, e.g. \"machbase-neo Notebook\" (default jupyter's)")
+	rtc := flag.Bool("rtc", false, "enable the real-time collaboration of jupyterlab, installs jupyter-collaboration with -install")
+	trash := flag.Bool("trash", false, "move deleted files to the trash instead of removing them, FileContentsManager.delete_to_trash (default jupyter default, enabled)")
+	maxUpload := flag.String("max-upload", "", "max request body size of uploads, e.g. 512M or 2G (default jupyter default)")
+	theme := flag.String("theme", "", "default jupyterlab theme: light, dark, dark-high-contrast or a theme name (default jupyter's)")
+	autosave := flag.Int("autosave", 0, "seconds between the notebook autosaves (0: jupyter default)")
+	noActivityTimeout := flag.Int("no-activity-timeout", 0, "seconds for jupyter to shut itself down with no kernels, terminals and requests, ServerApp.shutdown_no_activity_timeout (0: disabled)")
+	cullIdle := flag.Int("cull-idle", 0, "seconds to shut down an idle kernel (0: jupyter default)")
+	cullInterval := flag.Int("cull-interval", 0, "seconds between the idle kernel checks (0: jupyter default)")
+	cullBusy := flag.Bool("cull-busy", false, "cull the busy kernels too")
+	cullConnected := flag.Bool("cull-connected", false, "cull the kernels that have connected clients too")
+	noTerminals := flag.Bool("no-terminals", false, "disable the web terminal")
+	hardened := flag.Bool("hardened", false, "disable the web terminal, the password change and the extension manager, deny remote access unless -allow-remote is set, refuse -allow-root")
+	frameAncestor := flag.String("frame-ancestor", "", "origin allowed to embed jupyter in an iframe, e.g. http://127.0.0.1:5654 (default 'self' only)")
+	openBrowser := flag.Bool("open", false, "open jupyter in the default browser when it is ready")
+	statusFile := flag.String("status-file", "", "JSON file to write the status of jupyter")
+	logFormat := flag.String("log-format", "text", "log format: text, json")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn, error")
+	quiet := flag.Bool("quiet", false, "log only the warnings and errors unless -log-level is set, and discard the stdout of jupyter")
+	logBuffer := flag.Int("log-buffer", 200, "number of the last log lines kept for /logs of the admin api and the -status-file (0: disabled)")
+	logTime := flag.String("log-time", "local", "timezone of the log timestamps: local, utc")
+	logFile := flag.String("log-file", "", "log file instead of stdout/stderr")
+	logMaxSize := flag.Int64("log-max-size", 10*1024*1024, "log file size in bytes to rotate")
+	logMaxBackups := flag.Int("log-max-backups", 5, "number of rotated log files to keep")
+	kernelLogs := flag.Bool("kernel-logs", false, "write the jupyter log lines of each kernel to kernels/kernel-<id>.log in the dir of -log-file, or of the instance")
+	logJupyter := flag.Bool("log-jupyter", false, "write jupyter output to the -log-file too, instead of stdout/stderr")
+	stopSignal := flag.String("stop-signal", "term", "signal to stop jupyter gracefully before killing it after -shutdown-timeout: int, term (ignored on windows)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second, "time to wait for jupyter to exit before killing it")
+	startupTimeout := flag.Duration("startup-timeout", 60*time.Second, "time to wait for jupyter to be ready (0: do not wait)")
+	reuse := flag.Bool("reuse", false, "reuse the jupyter of the -status-file left running on the same port, base url and token instead of launching one")
+	force := flag.Bool("force", false, "start even if the pid file names a running process")
+	instance := flag.String("instance", "", "instance name for the default pid file, status file and jupyter dirs of multiple instances")
+	instanceBaseURL := flag.Bool("instance-base-url", false, "append the instance name to the base url")
+	dryRun := flag.Bool("dry-run", false, "print the jupyter command and environment without starting it")
+	showVersion := flag.Bool("version", false, "print the versions of neo-jupyter, python and jupyter, and exit")
+	configFile := flag.String("config", "", "YAML config file, command line flags take precedence")
 	flag.Parse()
+	cmdline := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
 
-	python := findPython()
-	if python == "" {
-		fmt.Fprintln(os.Stderr, "python not found")
-		os.Exit(1)
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			fatalf("invalid config: %v", err)
+		}
 	}
-	jupyter := findJupyterExecutable()
-	if jupyter == "" {
-		fmt.Fprintln(os.Stderr, "jupyter not found")
-		os.Exit(1)
+	if *instance != "" {
+		if !instanceRegexp.MatchString(*instance) || strings.Trim(*instance, ".") == "" {
+			fatalf("invalid instance %q, only letters, digits, '.', '_' and '-' are allowed", *instance)
+		}
+		if !isFlagSet("pid") {
+			*pid = fmt.Sprintf("neo-jupyter-%s.pid", *instance)
+		}
+		if !isFlagSet("status-file") {
+			*statusFile = fmt.Sprintf("neo-jupyter-%s.status.json", *instance)
+		}
+		if *instanceBaseURL {
+			*baseURL = strings.TrimRight(*baseURL, "/") + "/" + *instance + "/"
+		}
 	}
-
-	notebookDir := "."
-	if dir := os.Getenv("MACHBASE_NEO_FILE"); dir != "" {
-		toks := strings.Split(dir, string(filepath.ListSeparator))
-		if len(toks) > 0 {
-			notebookDir = toks[0]
+	if *showVersion {
+		printVersions(
+			jupyter.WithLogger(jupyter.NewStdLogger("text", io.Discard, io.Discard)),
+			jupyter.WithUI(*ui),
+			jupyter.WithPython(flagOrEnv("python", *pythonBin, "MACHBASE_NEO_PYTHON")),
+			jupyter.WithJupyter(flagOrEnv("jupyter", *jupyterBin, "MACHBASE_NEO_JUPYTER")),
+			jupyter.WithVenv(*venv),
+			jupyter.WithConda(*condaEnv),
+		)
+		return 0
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		fatalf("invalid log-format %q", *logFormat)
+	}
+	var logOut *rotateFile
+	if *logFile != "" {
+		rf, err := openRotateFile(*logFile, *logMaxSize, *logMaxBackups)
+		if err != nil {
+			fatalf("fail to open log file: %v", err)
 		}
+		logOut = rf
+		lg = jupyter.NewStdLogger(*logFormat, rf, rf)
+	} else {
+		lg = jupyter.NewStdLogger(*logFormat, os.Stdout, os.Stderr)
+	}
+	// an explicit -log-level wins over -quiet
+	if *quiet && !isFlagSet("log-level") {
+		*logLevel = "warn"
+	}
+	if err := lg.SetLevel(*logLevel); err != nil {
+		fatalf("%v", err)
+	}
+	if *logTime != "local" && *logTime != "utc" {
+		fatalf("invalid log-time %q", *logTime)
+	}
+	lg.SetUTC(*logTime == "utc")
+	if *logBuffer < 0 {
+		fatalf("invalid log-buffer %d", *logBuffer)
+	}
+	var logBuf *jupyter.LogBuffer
+	if *logBuffer > 0 {
+		logBuf = jupyter.NewLogBuffer(*logBuffer)
+		lg.SetBuffer(logBuf)
 	}
 
-	jl := &JupyterLash{
-		pythonBin:   python,
-		jupyterBin:  jupyter,
-		notebookDir: notebookDir,
+	listenPort := *port
+	if env := os.Getenv("MACHBASE_NEO_JUPYTER_PORT"); env != "" && !isFlagSet("port") {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			fatalf("invalid MACHBASE_NEO_JUPYTER_PORT %q", env)
+		}
+		listenPort = p
+	}
+	sockPath := expandPath(*sock)
+	if sockPath != "" {
+		for _, name := range []string{"port", "bind", "port-auto", "port-file"} {
+			if isFlagSet(name) {
+				fatalf("-sock and -%s are mutually exclusive", name)
+			}
+		}
+		if p, err := filepath.Abs(sockPath); err == nil {
+			sockPath = p
+		}
+		if *openBrowser {
+			lg.Warnf("-open is ignored with -sock, a browser can not open a unix socket")
+			*openBrowser = false
+		}
+	}
+	// keyed by the configured port, -port-auto may listen on another one
+	instanceName := *instance
+	if instanceName == "" && sockPath != "" {
+		sum := sha256.Sum256([]byte(sockPath))
+		instanceName = "sock-" + hex.EncodeToString(sum[:6])
+	} else if instanceName == "" {
+		instanceName = fmt.Sprintf("port-%d", listenPort)
+	}
+	if !*dryRun {
+		// held until exit, so that the pid file check and write below do not race
+		base, err := instanceDir(instanceName)
+		if err != nil {
+			fatalf("fail to get instance dir: %v", err)
+		}
+		lock, err := jupyter.LockInstance(filepath.Join(base, "neo-jupyter.lock"))
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer lock.Unlock()
+	}
+	if !*force {
+		if err := checkPidFile(*pid); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *adminAddr != "" && *adminToken == "" {
+		fatalf("-admin-token is required with -admin-addr")
+	}
+	var account *jupyter.Account
+	if *runUser != "" {
+		if *dockerImage != "" || *externalURL != "" {
+			fatalf("-user can not be used with -docker-image or -external-url")
+		}
+		a, err := jupyter.LookupAccount(*runUser, *runGroup)
+		if err != nil {
+			fatalf("invalid user: %v", err)
+		}
+		if euid := os.Geteuid(); euid != 0 && euid != a.Uid {
+			fatalf("-user requires root to run jupyter as %s", a.Name)
+		}
+		account = a
+	} else if *runGroup != "" {
+		fatalf("-group requires -user")
+	} else if os.Geteuid() == 0 && !*allowRoot && *dockerImage == "" && *externalURL == "" {
+		fatalf("refusing to run jupyter as root, use -user to run it as an unprivileged user, or -allow-root")
+	}
+	if *hardened && *allowRoot {
+		fatalf("-hardened can not be used with -allow-root, use -user to run jupyter as an unprivileged user")
+	}
+	if *reportURL != "" {
+		if u, err := url.Parse(*reportURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatalf("invalid report-url %q, must be an http or https url", *reportURL)
+		}
+		if *reportInterval <= 0 {
+			fatalf("invalid report-interval %s", *reportInterval)
+		}
+	}
+	stopSig, ok := stopSignals[strings.ToLower(*stopSignal)]
+	if !ok {
+		fatalf("invalid stop-signal %q, must be int or term", *stopSignal)
+	}
+	if *healthRetries < 1 {
+		fatalf("invalid health-retries %d", *healthRetries)
+	}
+	if *portRange < 1 {
+		fatalf("invalid port-range %d", *portRange)
+	}
+	switch *ui {
+	case "lab", "notebook", "server":
+	default:
+		fatalf("invalid ui %q, must be lab, notebook or server", *ui)
+	}
+	normBaseURL, err := jupyter.NormalizeBaseURL(*baseURL)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// an IPv6 address may be given bracketed as in a url, e.g. [::1]
+	if *bind, err = jupyter.NormalizeBind(*bind); err != nil {
+		fatalf("%v", err)
+	}
+	if listenPort < 1 || listenPort > 65535 {
+		fatalf("invalid port %d, must be 1-65535", listenPort)
 	}
-	jl.Start()
 
-	os.WriteFile(*pid, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)
+	if (*certFile == "") != (*keyFile == "") {
+		fatalf("both -certfile and -keyfile are required for TLS")
+	}
+	for _, f := range []string{*certFile, *keyFile} {
+		if f == "" {
+			continue
+		}
+		if err := checkReadable(f); err != nil {
+			fatalf("invalid TLS file: %v", err)
+		}
+	}
 
-	// wait Ctrl+C
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-	fmt.Println("started, press ctrl+c to stop...")
-	<-done
+	accessToken := *token
+	if accessToken == "auto" {
+		tok, err := generateToken()
+		if err != nil {
+			fatalf("fail to generate token: %v", err)
+		}
+		accessToken = tok
+	}
 
-	fmt.Println("stopping...")
-	jl.Stop()
-}
+	// -set is applied after the presets, so that it wins
+	presets := map[string]string{}
+	if *frameAncestor != "" {
+		if strings.ContainsAny(*frameAncestor, " \t;,'\"") {
+			fatalf("invalid frame-ancestor %q", *frameAncestor)
+		}
+		csp := fmt.Sprintf("frame-ancestors 'self' %s", *frameAncestor)
+		b, _ := json.Marshal(map[string]any{"headers": map[string]string{"Content-Security-Policy": csp}})
+		presets["ServerApp.tornado_settings"] = string(b)
+		lg.Warnf("%s: jupyter can be embedded by the pages of %s, allow only a trusted origin against clickjacking", csp, *frameAncestor)
+	}
+	var hardening []string
+	if *noTerminals || *hardened {
+		hardening = append(hardening, "ServerApp.terminals_enabled=False")
+	}
+	if *hardened {
+		hardening = append(hardening, hardenedSettings...)
+	}
+	for _, kv := range hardening {
+		k, v, _ := strings.Cut(kv, "=")
+		presets[k] = v
+	}
+	// it is set by WithAllowRemote, a proxy has to send a local Host header then
+	if *hardened && !isFlagSet("allow-remote") {
+		*allowRemote = false
+		hardening = append(hardening, "ServerApp.allow_remote_access=False")
+	}
+	if len(hardening) > 0 {
+		lg.Infof("hardening: %s", strings.Join(hardening, " "))
+	}
+	if *cullIdle < 0 || *cullInterval < 0 {
+		fatalf("invalid cull-idle %d or cull-interval %d", *cullIdle, *cullInterval)
+	}
+	if *cullIdle > 0 {
+		presets["MappingKernelManager.cull_idle_timeout"] = strconv.Itoa(*cullIdle)
+		if *cullInterval > 0 {
+			presets["MappingKernelManager.cull_interval"] = strconv.Itoa(*cullInterval)
+		}
+		presets["MappingKernelManager.cull_busy"] = jupyter.PyBool(*cullBusy)
+		presets["MappingKernelManager.cull_connected"] = jupyter.PyBool(*cullConnected)
+		lg.Infof("kernel culling: idle %ds, interval %s, busy %v, connected %v",
+			*cullIdle, secondsOrDefault(*cullInterval), *cullBusy, *cullConnected)
+	}
+	if *noActivityTimeout < 0 {
+		fatalf("invalid no-activity-timeout %d", *noActivityTimeout)
+	}
+	if *noActivityTimeout > 0 {
+		presets["ServerApp.shutdown_no_activity_timeout"] = strconv.Itoa(*noActivityTimeout)
+		lg.Infof("no activity timeout: jupyter shuts down after %ds without kernels, terminals and requests", *noActivityTimeout)
+	}
+	if *offline && *ui == "lab" {
+		for _, kv := range offlineLabSettings {
+			k, v, _ := strings.Cut(kv, "=")
+			presets[k] = v
+		}
+	}
+	if *maxUpload != "" {
+		n, err := parseSize(*maxUpload)
+		if err != nil || n <= 0 {
+			fatalf("invalid max-upload %q, must be bytes with an optional K, M, G or T suffix", *maxUpload)
+		}
+		presets["ServerApp.max_body_size"] = strconv.FormatInt(n, 10)
+		presets["ServerApp.max_buffer_size"] = strconv.FormatInt(n, 10)
+		lg.Infof("max upload: %s (%d bytes)", *maxUpload, n)
+	}
+	// jupyter deletes to the trash by default, only an explicit -trash changes it
+	if isFlagSet("trash") {
+		presets["FileContentsManager.delete_to_trash"] = jupyter.PyBool(*trash)
+	}
+	if t, ok := presets["FileContentsManager.delete_to_trash"]; !ok {
+		lg.Infof("trash: jupyter default, enabled if send2trash is installed")
+	} else if t == "True" {
+		lg.Infof("trash: enabled, deleted files are moved to the trash")
+	} else {
+		lg.Infof("trash: disabled, deleted files are removed permanently")
+	}
+	if *appTitle != "" {
+		switch *ui {
+		case "lab":
+			presets["LabApp.app_name"] = *appTitle
+		case "notebook":
+			presets["JupyterNotebookApp.app_name"] = *appTitle
+		default:
+			lg.Warnf("app-title has no effect with ui %q", *ui)
+		}
+		lg.Infof("app title: %s", *appTitle)
+	}
+	if *autosave < 0 {
+		fatalf("invalid autosave %d", *autosave)
+	}
+	// frontend settings that have no server option, written to overrides.json
+	labOverrides := map[string]any{}
+	if *autosave > 0 {
+		labOverrides["@jupyterlab/docmanager-extension:plugin"] = map[string]any{
+			"autosave":         true,
+			"autosaveInterval": *autosave,
+		}
+		lg.Infof("autosave: every %ds", *autosave)
+	}
+	if *theme != "" {
+		name, ok := labThemes[strings.ToLower(*theme)]
+		if !ok {
+			// a theme of an extension is not known here
+			name = *theme
+			lg.Warnf("unknown theme %q, it is used as is", *theme)
+		}
+		labOverrides["@jupyterlab/apputils-extension:themes"] = map[string]any{"theme": name}
+		lg.Infof("theme: %s", name)
+	}
+	if len(labOverrides) > 0 {
+		if *ui == "server" {
+			lg.Warnf("autosave and theme are settings of jupyterlab, they have no effect with ui %q", *ui)
+		}
+		dir, err := jupyterInstanceDir(instanceName, account)
+		if err != nil {
+			fatalf("fail to write lab settings: %v", err)
+		}
+		dir = filepath.Join(dir, "lab-settings")
+		if !*dryRun {
+			if err := writeLabOverrides(dir, account, labOverrides); err != nil {
+				fatalf("fail to write lab settings: %v", err)
+			}
+		}
+		presets["LabApp.app_settings_dir"] = dir
+	}
+	if len(allowOrigins) > 0 {
+		k, v, err := allowOriginSetting(allowOrigins)
+		if err != nil {
+			fatalf("invalid allow-origin: %v", err)
+		}
+		presets[k] = v
+	}
+	settings, err := applySets(presets, sets)
+	if err != nil {
+		fatalf("invalid -set: %v", err)
+	}
 
-type JupyterLash struct {
-	sync.RWMutex
-	pythonBin   string
-	jupyterBin  string
-	notebookDir string
-	cmd         *exec.Cmd
-}
+	var fileEnv []string
+	if *envFile != "" {
+		fileEnv, err = jupyter.ParseEnvFile(expandPath(*envFile))
+		if err != nil {
+			fatalf("invalid env-file: %v", err)
+		}
+	}
+	for _, kv := range envs {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			fatalf("invalid -env %q, must be KEY=VALUE", kv)
+		}
+	}
 
-func (jl *JupyterLash) Start() {
-	jl.Lock()
-	defer jl.Unlock()
-	if jl.cmd != nil {
-		return
+	var pythonPath []string
+	for _, p := range pythonPaths {
+		for _, tok := range filepath.SplitList(p) {
+			if tok = strings.TrimSpace(tok); tok == "" {
+				continue
+			}
+			tok = expandPath(tok)
+			if _, err := os.Stat(tok); err != nil {
+				lg.Warnf("invalid pythonpath: %v", err)
+			}
+			pythonPath = append(pythonPath, tok)
+		}
 	}
-	jl.start0()
-}
-
-func (jl *JupyterLash) Stop() {
-	jl.Lock()
-	defer jl.Unlock()
-	jl.stop0()
-}
-
-func (jl *JupyterLash) start0() {
-	cmd := exec.Command(jl.pythonBin, jl.jupyterBin, "lab",
-		"-y",
-		"--no-browser",
-		"--notebook-dir", jl.notebookDir,
-		"--ip=127.0.0.1",
-		"--port=8888",
-		"--ServerApp.base_url=/web/apps/neo-jupyter/base/",
-		"--ServerApp.allow_remote_access=True",
-		"--LabApp.token=''", // disable token
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	startWg := sync.WaitGroup{}
-	startWg.Add(1)
-	go func() {
-		err := cmd.Start()
+
+	jupyterDataDir, jupyterRuntimeDir := expandPath(*dataDir), expandPath(*runtimeDir)
+	if jupyterDataDir == "" || jupyterRuntimeDir == "" {
+		base, err := jupyterInstanceDir(instanceName, account)
 		if err != nil {
-			jl.cmd = nil
-			jl.logError("fail to start: cmd:%q error:%v", jl.jupyterBin, err)
-			startWg.Done()
-			return
-		} else {
-			jl.cmd = cmd
-			startWg.Done()
-		}
-		err = cmd.Wait()
+			fatalf("fail to get instance dir: %v", err)
+		}
+		if jupyterDataDir == "" {
+			jupyterDataDir = filepath.Join(base, "data")
+		}
+		if jupyterRuntimeDir == "" {
+			jupyterRuntimeDir = filepath.Join(base, "runtime")
+		}
+	}
+	for _, dir := range []string{jupyterDataDir, jupyterRuntimeDir} {
+		if *dryRun {
+			break
+		}
+		if err := mkdirOwned(dir, 0700, account); err != nil {
+			fatalf("fail to create jupyter dir: %v", err)
+		}
+	}
+
+	var notebookDir string
+	// a missing one is not created by -dry-run, and can not be checked then
+	notebookMissing := false
+	if isFlagSet("notebook-dir") {
+		notebookDir = expandPath(*nbDir)
+		_, err := os.Stat(notebookDir)
+		if *dryRun && os.IsNotExist(err) {
+			lg.Infof("notebook dir %s does not exist, it is created on start", notebookDir)
+			notebookMissing = true
+		} else if err := prepareNotebookDir(notebookDir); err != nil {
+			fatalf("invalid notebook dir: %v", err)
+		}
+		if os.IsNotExist(err) && account != nil && !*dryRun {
+			if err := account.Chown(notebookDir); err != nil {
+				fatalf("invalid notebook dir: %v", err)
+			}
+		}
+	} else {
+		notebookDir = envNotebookDir(os.Getenv("MACHBASE_NEO_FILE"))
+	}
+	notebookDir = absNotebookDir(notebookDir)
+	lg.Infof("notebook dir: %s", notebookDir)
+	if *seed && !*dryRun {
+		created, err := jupyter.SeedNotebookDir(notebookDir)
 		if err != nil {
-			jl.logError("fail to run: %v", err)
-		} else {
-			if jl.cmd != nil && jl.cmd.Process != nil {
-				jl.log("jupyter lab exit %d", jl.cmd.ProcessState.ExitCode())
+			lg.Warnf("fail to seed notebook dir: %v", err)
+		} else if len(created) > 0 {
+			lg.Infof("seeded notebook dir %s: %s", notebookDir, strings.Join(created, " "))
+			if account != nil {
+				for _, name := range created {
+					if err := account.Chown(filepath.Join(notebookDir, name)); err != nil {
+						lg.Warnf("fail to seed notebook dir: %v", err)
+					}
+				}
 			}
 		}
-		jl.cmd = nil
-	}()
-	startWg.Wait()
-}
+	}
 
-func (jl *JupyterLash) stop0() {
-	if jl.cmd == nil || jl.cmd.Process == nil {
-		return
+	opts := []jupyter.Option{
+		jupyter.WithLogger(lg),
+		jupyter.WithPython(flagOrEnv("python", *pythonBin, "MACHBASE_NEO_PYTHON")),
+		jupyter.WithJupyter(flagOrEnv("jupyter", *jupyterBin, "MACHBASE_NEO_JUPYTER")),
+		jupyter.WithNotebookDir(notebookDir),
+		jupyter.WithUI(*ui),
+		jupyter.WithBaseURL(normBaseURL),
+		jupyter.WithPort(listenPort),
+		jupyter.WithPortFile(*portFile),
+		jupyter.WithBind(*bind),
+		jupyter.WithAllowRemote(*allowRemote),
+		jupyter.WithToken(accessToken),
+		jupyter.WithTLS(*certFile, *keyFile),
+		jupyter.WithShutdownTimeout(*shutdownTimeout),
+		jupyter.WithStopSignal(stopSig),
+		jupyter.WithStartupTimeout(*startupTimeout),
+		jupyter.WithDiscoveryTimeout(*discoveryTimeout),
+		jupyter.WithHealthCheck(*healthInterval, *healthRetries),
+		jupyter.WithResourceLimits(*maxMemory, *maxProcs),
+		jupyter.WithIdleTimeout(*idleTimeout),
+		jupyter.WithDrain(*drain),
+		jupyter.WithUsageInterval(*usageInterval),
+		jupyter.WithMetrics(*metricsAddr),
+		jupyter.WithAdmin(*adminAddr, *adminToken),
+		jupyter.WithReport(*reportURL, *reportInterval),
+		jupyter.WithVenv(*venv),
+		jupyter.WithConda(*condaEnv),
+		jupyter.WithSettings(settings),
+		jupyter.WithJupyterDirs(jupyterDataDir, jupyterRuntimeDir),
+		jupyter.WithAccount(account),
+		jupyter.WithPythonPath(pythonPath...),
+		jupyter.WithEnvFile(fileEnv...),
+		jupyter.WithEnv(envs...),
+		jupyter.WithExtraArgs(append(jupyterArgs, flag.Args()...)...),
+		jupyter.WithStatusFile(*statusFile),
+		jupyter.WithSocket(sockPath),
+		jupyter.WithRequirements(expandPath(*requirements)),
+		jupyter.WithDocker(*dockerImage),
+		jupyter.WithExternalURL(*externalURL),
 	}
-	jl.cmd.Process.Signal(syscall.SIGTERM)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		count := 0
-		dur := 100 * time.Millisecond
-		tick := time.NewTicker(dur)
-		for range tick.C {
-			if jl.cmd == nil {
-				break
+	if *portAuto {
+		opts = append(opts, jupyter.WithPortAuto(*portRange))
+	}
+	if *restart {
+		opts = append(opts, jupyter.WithRestart(*restartMax))
+	}
+	if *rtc {
+		lg.Warnf("real-time collaboration: everyone who can open jupyter edits the same documents live, and the edits are not attributed to a user without an identity provider. Use a -token and do not share it beyond the collaborators")
+		opts = append(opts, jupyter.WithCollaboration())
+	}
+	if *offline {
+		if *install {
+			lg.Warnf("-install is disabled by -offline")
+		}
+		opts = append(opts, jupyter.WithOffline())
+	}
+	if *install && !*dryRun {
+		opts = append(opts, jupyter.WithInstall(*pipIndex, *installTimeout))
+	}
+	if *quiet {
+		opts = append(opts, jupyter.WithDiscardStdout())
+	}
+	if *skipChecks || notebookMissing {
+		opts = append(opts, jupyter.WithSkipChecks())
+	}
+	if logBuf != nil {
+		opts = append(opts, jupyter.WithLogBuffer(logBuf))
+	}
+	if *kernelLogs && !*dryRun {
+		dir := filepath.Join(filepath.Dir(*logFile), "kernels")
+		if *logFile == "" {
+			base, err := instanceDir(instanceName)
+			if err != nil {
+				fatalf("fail to get instance dir: %v", err)
 			}
-			count++
-			if time.Duration(count)*dur > 5*time.Second {
-				jl.logError("timeout")
-				break
+			dir = filepath.Join(base, "kernels")
+		}
+		lg.Infof("kernel logs: %s", dir)
+		opts = append(opts, jupyter.WithKernelLogs(dir))
+	}
+	if *reuse {
+		if *statusFile == "" {
+			fatalf("-reuse requires -status-file or -instance to find the jupyter to reuse")
+		}
+		opts = append(opts, jupyter.WithReuse())
+	}
+	// the parent process waits for the ready line on stdout, even with -log-file
+	opts = append(opts, jupyter.WithReadyLine(os.Stdout))
+	if *openBrowser {
+		opts = append(opts, jupyter.WithOpenBrowser())
+	}
+	if *genConfig {
+		opts = append(opts, jupyter.WithGeneratedConfig(*keepConfig))
+	}
+	if !*dryRun {
+		opts = append(opts, jupyter.WithLabExtensions(enableExts, disableExts))
+	}
+	if *installKernel && !*dryRun {
+		opts = append(opts, jupyter.WithInstallKernel())
+	}
+	if logOut != nil {
+		defer logOut.Close()
+		if !*logJupyter {
+			jupyterLg := jupyter.NewStdLogger(*logFormat, os.Stdout, os.Stderr)
+			jupyterLg.SetLevel(*logLevel)
+			jupyterLg.SetUTC(*logTime == "utc")
+			jupyterLg.SetBuffer(logBuf)
+			opts = append(opts, jupyter.WithJupyterLogger(jupyterLg))
+		}
+	}
+	jl := jupyter.New(opts...)
+	if *externalURL != "" {
+		if _, err := jupyter.ParseExternalURL(*externalURL); err != nil {
+			fatalf("invalid external-url: %v", err)
+		}
+	} else if err := jl.Preflight(); err != nil {
+		lg.Errorf("%v", err)
+		// exit with the status of pip if the requirements failed
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+		return 1
+	}
+	if *dryRun {
+		if err := jl.DryRun(os.Stdout); err != nil {
+			fatalf("%v", err)
+		}
+		return 0
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := jl.StartContext(ctx); err != nil {
+		lg.Errorf("%v", err)
+		return 1
+	}
+
+	os.WriteFile(*pid, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)
+	defer removePidFile(*pid)
+
+	// wait Ctrl+C
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	lg.Infof("started, press ctrl+c to stop...")
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-jl.Stopped():
+			break wait
+		case <-hup:
+			if *configFile == "" {
+				lg.Infof("restarting...")
+				if err := jl.Restart(); err != nil {
+					lg.Errorf("%v", err)
+				}
+				continue
+			}
+			lg.Infof("reloading %s...", *configFile)
+			dir, newSettings, err := reloadConfigFile(*configFile, cmdline, notebookDir, settings, presets)
+			if err != nil {
+				lg.Errorf("fail to reload: %v", err)
+				continue
 			}
+			logReload(notebookDir, dir, settings, newSettings)
+			if err := jl.Reload(dir, newSettings); err != nil {
+				lg.Errorf("%v", err)
+			}
+			notebookDir, settings = dir, newSettings
+		case <-jl.Failed():
+			jl.Stop()
+			code := jl.ExitCode()
+			if code != 0 {
+				lg.Errorf("jupyter exited with %d", code)
+			}
+			if code < 0 {
+				// killed by a signal
+				code = 1
+			}
+			return code
 		}
+	}
+
+	// the second signal kills jupyter without waiting for it. It is notified
+	// before cancel() resets the signals, or it would kill neo-jupyter only.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	cancel()
+	go func() {
+		<-sig
+		lg.Infof("killing...")
+		jl.Kill()
 	}()
-	wg.Wait()
+	lg.Infof("stopping...")
+	if err := jl.Stop(); err != nil {
+		lg.Errorf("%v", err)
+	}
+	return 0
 }
 
-func findPython() string {
-	list := []string{
-		"/usr/bin/python3",
-		"/usr/bin/python",
+// applySets returns the presets with -set key=value applied
+func applySets(presets map[string]string, sets []string) (map[string]string, error) {
+	settings := maps.Clone(presets)
+	for _, kv := range sets {
+		k, v, err := jupyter.ParseSetting(kv)
+		if err != nil {
+			return nil, err
+		}
+		settings[k] = v
 	}
-	return findPath(list)
+	return settings, nil
 }
 
-func findJupyterExecutable() string {
-	list := []string{
-		"${HOME}/.local/bin/jupyter",
-		"/home/${USER}/.local/bin/jupyter",
-		"/usr/local/bin/jupyter",
+func secondsOrDefault(sec int) string {
+	if sec == 0 {
+		return "default"
 	}
-	return findPath(list)
+	return fmt.Sprintf("%ds", sec)
+}
+
+// offlineLabSettings stop jupyterlab fetching the news and checking for updates
+var offlineLabSettings = []string{
+	"LabApp.news_url=None",
+	"LabApp.check_for_updates_class=jupyterlab.NeverCheckForUpdate",
+}
+
+// hardenedSettings are the jupyter settings of -hardened in addition to -no-terminals,
+// the ones that are not jupyter's defaults. Kernels still run any code of the notebook.
+var hardenedSettings = []string{
+	// no password set from the login page over the token
+	"ServerApp.allow_password_change=False",
+	// the extension manager of jupyterlab installs packages with pip
+	"LabApp.extension_manager=readonly",
 }
 
-func findPath(list []string) string {
-	for _, path := range list {
-		path = os.ExpandEnv(path)
-		if _, err := os.Stat(path); err == nil {
-			return path
+// parseSize parses bytes with an optional K, M, G or T suffix of 1024 multiples,
+// e.g. "512M". A trailing "B" or "iB" is allowed, e.g. "512MiB".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mul := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			mul = 1 << (10 * (i + 1))
+			s = s[:n-1]
 		}
 	}
-	return ""
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/mul {
+		return 0, fmt.Errorf("size %s is too large", s)
+	}
+	return n * mul, nil
 }
 
-func (jl *JupyterLash) log(f string, args ...any) {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stdout, f)
-	} else {
-		fmt.Fprintf(os.Stdout, f+"\n", args...)
+// stopSignals are the signals of -stop-signal, jupyter shuts down on both
+// without a confirmation as it runs with -y
+var stopSignals = map[string]syscall.Signal{
+	"int":     syscall.SIGINT,
+	"sigint":  syscall.SIGINT,
+	"term":    syscall.SIGTERM,
+	"sigterm": syscall.SIGTERM,
+}
+
+// labThemes are the themes bundled with jupyterlab by the lower case name and alias
+var labThemes = map[string]string{
+	"light":                         "JupyterLab Light",
+	"dark":                          "JupyterLab Dark",
+	"dark-high-contrast":            "JupyterLab Dark High Contrast",
+	"jupyterlab light":              "JupyterLab Light",
+	"jupyterlab dark":               "JupyterLab Dark",
+	"jupyterlab dark high contrast": "JupyterLab Dark High Contrast",
+}
+
+// writeLabOverrides writes the overrides.json of the default lab settings to
+// dir, the lab settings dir of the instance that is LabApp.app_settings_dir.
+func writeLabOverrides(dir string, account *jupyter.Account, overrides map[string]any) error {
+	if err := mkdirOwned(dir, 0700, account); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(overrides, "", "  ")
+	file := filepath.Join(dir, "overrides.json")
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		return err
 	}
+	if account != nil {
+		return account.Chown(file)
+	}
+	return nil
 }
 
-func (jl *JupyterLash) logError(f string, args ...any) {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, f)
-	} else {
-		fmt.Fprintf(os.Stderr, f+"\n", args...)
+// allowOriginSetting returns ServerApp.allow_origin for a single origin or '*',
+// and ServerApp.allow_origin_pat matching any of origins otherwise.
+// An origin that has a regular expression meta character other than '.' is a pattern.
+func allowOriginSetting(origins []string) (string, string, error) {
+	const meta = `^$*+?()[]{}|\`
+	if len(origins) == 1 && (origins[0] == "*" || !strings.ContainsAny(origins[0], meta)) {
+		return "ServerApp.allow_origin", origins[0], nil
+	}
+	pats := make([]string, len(origins))
+	for i, o := range origins {
+		if o == "*" {
+			return "", "", fmt.Errorf("'*' can not be used with other origins")
+		}
+		if !strings.ContainsAny(o, meta) {
+			o = regexp.QuoteMeta(o)
+		}
+		if _, err := regexp.Compile(o); err != nil {
+			return "", "", err
+		}
+		pats[i] = "(" + o + ")"
+	}
+	return "ServerApp.allow_origin_pat", "^(" + strings.Join(pats, "|") + ")$", nil
+}
+
+// printVersions prints the versions of neo-jupyter and the detected python and jupyter
+func printVersions(opts ...jupyter.Option) {
+	fmt.Printf("neo-jupyter %s\n", version)
+	vers, err := jupyter.New(opts...).DetectVersions()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	fmt.Printf("python %s\n", orUnknown(vers.Python))
+	fmt.Printf("%s %s\n", vers.UI, orUnknown(vers.Jupyter))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// checkPidFile returns an error if the pid file names a running process
+func checkPidFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
 	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err == nil && pid > 0 && pid != os.Getpid() && jupyter.ProcessAlive(pid) {
+		return fmt.Errorf("already running (pid %d), see %s", pid, path)
+	}
+	lg.Infof("reclaiming stale pid file %s", path)
+	return nil
+}
+
+// removePidFile removes the pid file only if it still has our pid
+func removePidFile(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	os.Remove(path)
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func checkReadable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// flagOrEnv returns the flag value if the flag is set, otherwise the environment variable
+func flagOrEnv(name string, value string, env string) string {
+	if isFlagSet(name) {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// expandPath expands environment variables and a leading '~' in path
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// envNotebookDir returns the first existing, writable directory in the MACHBASE_NEO_FILE
+// list, or "." if none of them is usable. Nothing is created, a missing one may be
+// a volume that is not mounted.
+func envNotebookDir(env string) string {
+	for _, tok := range strings.Split(env, string(filepath.ListSeparator)) {
+		dir := strings.TrimSpace(tok)
+		if dir == "" {
+			continue
+		}
+		dir = expandPath(dir)
+		fi, err := os.Stat(dir)
+		if err == nil && !fi.IsDir() {
+			err = fmt.Errorf("%s is not a directory", dir)
+		}
+		if err == nil {
+			err = jupyter.CheckWritable(dir)
+		}
+		if err != nil {
+			lg.Infof("skip notebook dir %s: %v", dir, err)
+			continue
+		}
+		return dir
+	}
+	if env != "" {
+		lg.Warnf("no usable notebook dir in MACHBASE_NEO_FILE, using current directory")
+	}
+	return "."
+}
+
+// absNotebookDir returns dir as an absolute path, so that jupyter does not
+// depend on the working directory the launcher was started in
+func absNotebookDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		lg.Warnf("fail to resolve notebook dir %s: %v", dir, err)
+		return dir
+	}
+	return abs
+}
+
+var instanceRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// instanceDir returns the directory of the instance name in the user cache dir
+func instanceDir(name string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "neo-jupyter", name), nil
+}
+
+// jupyterInstanceDir returns the instance dir of the jupyter dirs,
+// that is in the cache dir of the account if jupyter runs as another user.
+func jupyterInstanceDir(name string, account *jupyter.Account) (string, error) {
+	if account == nil {
+		return instanceDir(name)
+	}
+	cache := filepath.Join(account.Home, ".cache")
+	if runtime.GOOS == "darwin" {
+		cache = filepath.Join(account.Home, "Library", "Caches")
+	}
+	return filepath.Join(cache, "neo-jupyter", name), nil
+}
+
+// mkdirOwned is os.MkdirAll that gives the dirs it creates to the account if not nil
+func mkdirOwned(dir string, perm os.FileMode, account *jupyter.Account) error {
+	var created []string
+	for p := filepath.Clean(dir); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil || filepath.Dir(p) == p {
+			break
+		}
+		created = append(created, p)
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	if account == nil {
+		return nil
+	}
+	for _, p := range created {
+		if err := os.Lchown(p, account.Uid, account.Gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareNotebookDir creates dir if it does not exist,
+// and returns an error if it is not a writable directory.
+func prepareNotebookDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		fi, err = os.Stat(dir)
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if fi.Mode().Perm()&0222 == 0 {
+		return fmt.Errorf("%s is not writable", dir)
+	}
+	return nil
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
+
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }